@@ -17,14 +17,17 @@
 package IBMCloudEnv
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"github.com/oliveagle/jsonpath"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 const PREFIX_PATTERN_CF = "cloudfoundry"
@@ -32,13 +35,45 @@ const PREFIX_PATTERN_ENV = "env"
 const PREFIX_PATTERN_FILE = "file"
 const PREFIX_PATTERN_USER = "user-provided"
 
+// SELECTOR_RECURSIVE_PREFIX marks a key to search for at any depth, e.g. ..writer.apikey
+const SELECTOR_RECURSIVE_PREFIX = ".."
+
+// settingsKeys are the top-level keys of a mappings file that are not mappings
+var settingsKeys = map[string]bool{"version": true, "jsonpath": true, "recursiveSearch": true, "resolution": true}
+
 var loadedMappings = make(map[string]interface{})
+var mappingsMutex sync.RWMutex
+
+// mappingDefinition keeps the configuration of a loaded mapping so it can be resolved again.
+// Entries of version 2 files are keyed "mapping.key" and remember their group and key.
+type mappingDefinition struct {
+	config   gjson.Result
+	template bool
+	group    string
+	key      string
+}
+
+var definitionsMutex sync.RWMutex
+var mappingDefinitions = make(map[string]mappingDefinition)
+
+// currentMappings holds the names of the mappings defined by the last Initialize
+var currentMappings = make(map[string]bool)
 
 func Initialize(mappingsFilePath string) string {
+	mappingsFilePath, _ = InitializeContext(context.Background(), mappingsFilePath)
+	return mappingsFilePath
+}
+
+// InitializeContext is Initialize with a context whose deadline and cancellation apply to
+// every search pattern. Resolution stops when the context ends, and the mappings it left
+// unresolved are returned as an IncompleteError.
+func InitializeContext(ctx context.Context, mappingsFilePath string) (string, error) {
+	start := time.Now()
 	json, err := ioutil.ReadFile(mappingsFilePath)
 	if err != nil {
 		log.Error(err)
 	}
+	loadErr := err
 	dir, err := os.Getwd()
 	if err != nil {
 		log.Error(err)
@@ -46,80 +81,239 @@ func Initialize(mappingsFilePath string) string {
 	mappingsFilePath = dir + mappingsFilePath
 	result := gjson.Parse(string(json))
 	version := result.Get("version").Int()
+	ctx, incomplete := withIncompleteMappings(ctx)
+	ctx, span := startSpan(ctx, "ibmcloudenv.Initialize",
+		attribute.String("ibmcloudenv.mappings_file", mappingsFilePath),
+		attribute.Int64("ibmcloudenv.version", version))
+	defer span.End()
+	resetCurrentMappings()
+	resetMappingStatuses()
+	resetVCAP()
+	resetVault()
+	resetSecretsManager()
+	resetHTTP()
+	if result.Get("jsonpath").String() == JSONPATH_MODE_RFC9535 {
+		setJSONPathMode(JSONPATH_MODE_RFC9535)
+	} else {
+		setJSONPathMode(JSONPATH_MODE_COMPAT)
+	}
+	setRecursiveSearchStrict(result.Get("recursiveSearch").String() == RECURSIVE_SEARCH_STRICT)
+	lazy := result.Get("resolution").String() == RESOLUTION_LAZY
+	resetLazy(lazy)
+	templates := make(map[string]gjson.Result)
 	result.ForEach(func(key, value gjson.Result) bool {
-		if !result.Get("version").Exists() {
-			processMapping(key.String(), value)
+		if settingsKeys[key.String()] {
+			return true
+		} else if lazy && version == 2 {
+			value.ForEach(func(entry, config gjson.Result) bool {
+				definition := mappingDefinition{config: config, group: key.String(), key: entry.String()}
+				defineMapping(key.String()+"."+entry.String(), definition)
+				deferMapping(key.String()+"."+entry.String(), definition)
+				return true
+			})
+		} else if lazy && (value.Get("template").Exists() || !result.Get("version").Exists() || version == 1) {
+			definition := mappingDefinition{config: value, template: value.Get("template").Exists()}
+			defineMapping(key.String(), definition)
+			deferMapping(key.String(), definition)
+		} else if value.Get("template").Exists() && version != 2 {
+			defineMapping(key.String(), mappingDefinition{config: value, template: true})
+			templates[key.String()] = value
+		} else if !result.Get("version").Exists() {
+			defineMapping(key.String(), mappingDefinition{config: value})
+			processMapping(ctx, key.String(), value)
 		} else if version == 1 {
-			processMapping(key.String(), value)
+			defineMapping(key.String(), mappingDefinition{config: value})
+			processMapping(ctx, key.String(), value)
 		} else if version == 2 {
-			processMappingV2(key.String(), value)
+			processMappingV2(ctx, key.String(), value)
 		}
 		return true
 	})
-	return mappingsFilePath
+	resolveTemplates(ctx, templates)
+	if loadErr == nil {
+		loadErr = incomplete.err(ctx)
+	}
+	if loadErr != nil {
+		span.SetStatus(codes.Error, loadErr.Error())
+	}
+	recordLoad(mappingsFilePath, loadErr, time.Since(start))
+	return mappingsFilePath, loadErr
 }
 
-func processMapping(mappingName string, config gjson.Result) bool {
+func processMapping(ctx context.Context, mappingName string, config gjson.Result) bool {
+	ctx, span := startSpan(ctx, "ibmcloudenv.mapping", attribute.String("ibmcloudenv.mapping", mappingName))
 	searchPatterns := config.Get("searchPatterns")
 	if !searchPatterns.Exists() || len(searchPatterns.Array()) == 0 {
 		log.Warnln("No searchPatterns found for mapping", mappingName)
+		recordStatus(mappingName, "", false)
+		endSpan(span, false, "")
 		return false
 	}
-	searchPatterns.ForEach(func(_, searchPattern gjson.Result) bool {
-		value, OK := processSearchPattern(mappingName, searchPattern.String())
-		if OK {
-			loadedMappings[mappingName] = value
-			return false
-		} else {
-			return true
-		}
-
-	})
+	value, pattern, OK := resolveSearchPatterns(ctx, mappingName, config)
+	if OK {
+		storeMapping(mappingName, value)
+	} else {
+		markIncomplete(ctx, mappingName)
+	}
+	recordStatus(mappingName, pattern, OK)
+	endSpan(span, OK, patternSource(pattern))
 
 	return true
 }
 
-func processMappingV2(mappingName string, config gjson.Result) {
+func processMappingV2(ctx context.Context, mappingName string, config gjson.Result) {
 	config.ForEach(func(key, value gjson.Result) bool {
-		searchPatterns := value.Get("searchPatterns")
-		if !searchPatterns.Exists() || len(searchPatterns.Array()) == 0 {
-			log.Warningln("No credentials found uusing searchPatterns under ", mappingName)
+		defineMapping(mappingName+"."+key.String(), mappingDefinition{config: value, group: mappingName, key: key.String()})
+		processMappingV2Entry(ctx, mappingName, key.String(), value)
+		return true
+	})
+}
+
+func processMappingV2Entry(ctx context.Context, mappingName, key string, config gjson.Result) bool {
+	ctx, span := startSpan(ctx, "ibmcloudenv.mapping", attribute.String("ibmcloudenv.mapping", mappingName+"."+key))
+	searchPatterns := config.Get("searchPatterns")
+	if !searchPatterns.Exists() || len(searchPatterns.Array()) == 0 {
+		log.Warningln("No credentials found uusing searchPatterns under ", mappingName)
+	}
+
+	// keys of version 2 mappings are named mapping.key in logs, transform errors, resolution
+	// attempt metrics, statuses and spans alike; logs used to name them $mapping[key]
+	resolved, pattern, ok := resolveSearchPatterns(ctx, mappingName+"."+key, config)
+	recordStatus(mappingName+"."+key, pattern, ok)
+	endSpan(span, ok, patternSource(pattern))
+	if !ok {
+		markIncomplete(ctx, mappingName+"."+key)
+	} else {
+		mappingsMutex.Lock()
+		defer mappingsMutex.Unlock()
+		_, exists := loadedMappings[mappingName]
+		if !exists {
+			loadedMappings[mappingName] = make(map[string]string)
 		}
 
-		searchPatterns.ForEach(func(_, searchPattern gjson.Result) bool {
-			value, ok := processSearchPattern(fmt.Sprintf("$%s[%s]", mappingName, key.String()), searchPattern.String())
-			if ok {
-				_, exists := loadedMappings[mappingName]
-				if !exists {
-					loadedMappings[mappingName] = make(map[string]string)
-				}
+		loadedMappings[mappingName].(map[string]string)[key] = resolved
+	}
+	return ok
+}
+
+func storeMapping(mappingName, value string) {
+	mappingsMutex.Lock()
+	defer mappingsMutex.Unlock()
+	loadedMappings[mappingName] = value
+}
+
+func defineMapping(name string, definition mappingDefinition) {
+	definitionsMutex.Lock()
+	defer definitionsMutex.Unlock()
+	mappingDefinitions[name] = definition
+	currentMappings[name] = true
+}
+
+func resetCurrentMappings() {
+	definitionsMutex.Lock()
+	defer definitionsMutex.Unlock()
+	currentMappings = make(map[string]bool)
+}
+
+func currentMapping(name string) bool {
+	definitionsMutex.RLock()
+	defer definitionsMutex.RUnlock()
+	return currentMappings[name]
+}
+
+// resolveDefinition resolves a loaded mapping again and reports whether it resolved
+func resolveDefinition(ctx context.Context, name string, definition mappingDefinition) bool {
+	if definition.template {
+		return len(resolveTemplates(ctx, map[string]gjson.Result{name: definition.config})) == 0
+	}
+	if definition.group != "" {
+		return processMappingV2Entry(ctx, definition.group, definition.key, definition.config)
+	}
+	processMapping(ctx, name, definition.config)
+	return mappingResolved(name)
+}
 
-				loadedMappings[mappingName].(map[string]string)[key.String()] = value
-				return false
-			} else {
+// patternOptions are the settings an object search pattern gives its prefix
+type patternOptions struct {
+	format string
+	http   gjson.Result
+}
+
+// resolveSearchPatterns tries each search pattern of a mapping in order and returns the first value found
+// together with the pattern that found it.
+// A pattern is either a plain string or an object of the form
+// {"pattern": "env:NAME", "when": {"platform": "kubernetes"}, "transform": ["trim"]}; patterns whose
+// condition does not match the current platform are skipped. Pattern transforms are applied
+// before the transforms of the mapping itself. A "format" field tells the file prefix how to
+// parse files whose extension does not give the format away, and an "http" field configures
+// requests of the http and https prefixes.
+func resolveSearchPatterns(ctx context.Context, mappingName string, config gjson.Result) (string, string, bool) {
+	value, pattern, OK := "", "", false
+	config.Get("searchPatterns").ForEach(func(_, searchPattern gjson.Result) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		pattern = searchPattern.String()
+		transforms := gjson.Result{}
+		options := patternOptions{}
+		if searchPattern.IsObject() {
+			if !conditionMatches(searchPattern.Get("when")) {
+				log.Debugln("Skipping searchPattern", searchPattern.Get("pattern").String(), "for mapping", mappingName, "on platform", Platform())
 				return true
 			}
-		})
-
-		return true
+			pattern = searchPattern.Get("pattern").String()
+			transforms = searchPattern.Get("transform")
+			options = patternOptions{format: searchPattern.Get("format").String(), http: searchPattern.Get("http")}
+		}
+		_, span := startSpan(ctx, "ibmcloudenv.searchPattern",
+			attribute.String("ibmcloudenv.mapping", mappingName),
+			attribute.String("ibmcloudenv.prefix", patternSource(pattern)),
+			attribute.String("ibmcloudenv.path", redactPattern(pattern)))
+		value, OK = awaitSearchPattern(ctx, mappingName, pattern, options)
+		if OK {
+			value, OK = applyTransforms(mappingName, value, transforms, config.Get("transform"))
+		}
+		recordAttempt(mappingName, pattern, OK)
+		endSpan(span, OK, "")
+		return !OK
 	})
+	if !OK {
+		pattern = ""
+	}
+	return value, pattern, OK
 }
 
-func processSearchPattern(mappingName string, searchPattern string) (string, bool) {
-	patternComponents := strings.Split(searchPattern, ":")
+func processSearchPattern(ctx context.Context, mappingName string, searchPattern string, options patternOptions) (string, bool) {
+	patternComponents := splitSearchPattern(searchPattern)
 	value := ""
 	OK := false
 	switch patternComponents[0] {
 	case PREFIX_PATTERN_FILE:
-		value, OK = processFileSearchPattern(patternComponents)
+		value, OK = processFileSearchPattern(patternComponents, options.format)
 	case PREFIX_PATTERN_CF:
 		value, OK = processCFSearchPattern(patternComponents)
 	case PREFIX_PATTERN_ENV:
 		value, OK = processEnvSearchPattern(patternComponents)
 	case PREFIX_PATTERN_USER:
 		value, OK = processUserProvidedSearchPattern(patternComponents)
+	case PREFIX_PATTERN_ENCFILE:
+		value, OK = processEncryptedFileSearchPattern(patternComponents)
+	case PREFIX_PATTERN_SOPS:
+		value, OK = processSopsSearchPattern(patternComponents)
+	case PREFIX_PATTERN_DOTENV:
+		value, OK = processDotenvSearchPattern(patternComponents)
+	case PREFIX_PATTERN_VAULT:
+		value, OK = processVaultSearchPattern(ctx, patternComponents)
+	case PREFIX_PATTERN_SECRETS_MANAGER:
+		value, OK = processSecretsManagerSearchPattern(ctx, patternComponents)
+	case PREFIX_PATTERN_CONSUL:
+		value, OK = processConsulSearchPattern(ctx, patternComponents)
+	case PREFIX_PATTERN_ETCD:
+		value, OK = processEtcdSearchPattern(ctx, patternComponents)
+	case PREFIX_PATTERN_HTTP, PREFIX_PATTERN_HTTPS:
+		value, OK = processHTTPSearchPattern(ctx, patternComponents, options.http)
 	default:
-		log.Warnln("Unknown searchPattern prefix", patternComponents[0], "Supported prefixes: user-provided, cloudfoundry, env, file")
+		log.Warnln("Unknown searchPattern prefix", patternComponents[0], "Supported prefixes: user-provided, cloudfoundry, env, file, encfile, sops, dotenv, vault, secretsmanager, consul, etcd, http, https")
 		return "", false
 	}
 	if !OK {
@@ -128,136 +322,196 @@ func processSearchPattern(mappingName string, searchPattern string) (string, boo
 	return value, true
 }
 
-func processFileSearchPattern(patternComponents []string) (string, bool) {
-	filePath, _ := os.Getwd()
-	if _, err := os.Stat(filePath); err != nil {
-		log.Errorln("File does not exist", filePath)
+// splitSearchPattern splits a pattern on ":", keeping a JSONPath at the end, which may
+// contain ":" itself, in one component
+func splitSearchPattern(searchPattern string) []string {
+	components := strings.Split(searchPattern, ":")
+	for i, component := range components {
+		if strings.HasPrefix(component, "$") {
+			return append(components[:i], strings.Join(components[i:], ":"))
+		}
+	}
+	return components
+}
+
+// processFileSearchPattern returns the file content, or applies the JSONPath after converting
+// YAML, TOML, INI and properties files to JSON
+func processFileSearchPattern(patternComponents []string, format string) (string, bool) {
+	content, ok := readProjectFile(patternComponents[1])
+	if !ok {
 		return "", false
-	} else {
-		fullPathName := filePath + patternComponents[1]
-		json, err := ioutil.ReadFile(fullPathName)
+	}
+	if len(patternComponents) == 3 {
+		json, err := convertToJSON(content, fileFormat(patternComponents[1], format))
 		if err != nil {
-			log.Errorln(err)
+			log.Errorln("Failed to parse", patternComponents[1], err)
 			return "", false
 		}
-		if len(patternComponents) == 3 {
-			return processJSONPath(string(json), patternComponents[2])
-		} else {
-			return string(json), true
-		}
+		return processSelector(json, patternComponents[2])
+	} else {
+		return string(content), true
+	}
+}
+
+// readProjectFile reads a file whose path is given relative to the working directory
+func readProjectFile(path string) ([]byte, bool) {
+	filePath, _ := os.Getwd()
+	if _, err := os.Stat(filePath); err != nil {
+		log.Errorln("File does not exist", filePath)
+		return nil, false
+	}
+	fullPathName := filePath + path
+	content, err := ioutil.ReadFile(fullPathName)
+	if err != nil {
+		log.Errorln(err)
+		return nil, false
 	}
+	return content, true
 }
 
 func processCFSearchPattern(patternComponents []string) (string, bool) {
-	vcapServicesString, ok_service := os.LookupEnv("VCAP_SERVICES")
-	vcapApplicationString, ok_app := os.LookupEnv("VCAP_APPLICATION")
-	if !ok_service && !ok_app {
+	vcap := currentVCAP()
+	if !vcap.servicesFound && !vcap.applicationFound {
 		return "", false
-	} else {
-		if patternComponents[1][0] == '$' {
-			value, OK := processJSONPath(vcapServicesString, patternComponents[1])
-			if OK {
-				return value, true
-			} else {
-				return processJSONPath(vcapApplicationString, patternComponents[1])
-			}
-		} else {
-			// patternComponents[1] is a service instance name, find it in VCAP_SERVICES and return credentials object
-			json := gjson.Parse(vcapServicesString)
-			res, ok := "", false
-			json.ForEach(func(k, v gjson.Result) bool {
-				v.ForEach(func(_, item gjson.Result) bool {
-					if item.Get("name").String() == patternComponents[1] {
-						res, ok = item.Get("credentials").String(), true
-						return false
-					} else {
-						return true
-					}
-				})
-				if ok {
-					return false
-				} else {
-					return true
-				}
-			})
-			return res, ok
-
+	}
+	selector := patternComponents[1]
+	switch {
+	case strings.HasPrefix(selector, "$") || strings.HasPrefix(selector, SELECTOR_RECURSIVE_PREFIX):
+		if value, OK := vcap.services.selectValue(selector); OK {
+			return value, true
 		}
+		return vcap.application.selectValue(selector)
+	case strings.HasPrefix(selector, CF_SELECTOR_LABEL):
+		return firstCredentials(vcap.byLabel[strings.TrimPrefix(selector, CF_SELECTOR_LABEL)])
+	case strings.HasPrefix(selector, CF_SELECTOR_TAG):
+		return firstCredentials(vcap.byTag[strings.TrimPrefix(selector, CF_SELECTOR_TAG)])
+	default:
+		// selector is a service instance name, return the credentials object of the instance
+		instance, ok := vcap.byName[selector]
+		if !ok {
+			return "", false
+		}
+		return formatGJSON(instance.Get("credentials"))
 	}
 }
 
 func processEnvSearchPattern(patternComponents []string) (string, bool) {
 	value, OK := os.LookupEnv(patternComponents[1])
 	if OK && (len(patternComponents) == 3) {
-		return processJSONPath(value, patternComponents[2])
+		return processSelector(value, patternComponents[2])
 	}
 	return value, OK
 }
 
+// processUserProvidedSearchPattern finds a user-provided service instance by name and selects
+// the credential key from it: a JSONPath relative to the instance when it starts with $,
+// otherwise a key searched for at any depth, optionally marked with a leading ..
 func processUserProvidedSearchPattern(patternComponents []string) (string, bool) {
-	vcapServicesString, ok := os.LookupEnv("VCAP_SERVICES")
+	vcap := currentVCAP()
+	if !vcap.servicesFound || len(patternComponents) != 3 {
+		return "", false
+	}
+	if !vcap.services.valid {
+		log.Errorln("Failed to parse", VCAP_SERVICES_ENV)
+		return "", false
+	}
+	instance, ok := vcap.userProvided[patternComponents[1]]
 	if !ok {
 		return "", false
 	}
-	if len(patternComponents) == 3 {
-		serviceName := patternComponents[1]
-		return processJSONCredentials(vcapServicesString, serviceName, patternComponents[2])
+	credkey := patternComponents[2]
+	if strings.HasPrefix(credkey, "$") {
+		return instance.selectValue(credkey)
 	}
-	return "", false
+	return deepSearch(instance.result, strings.TrimPrefix(credkey, SELECTOR_RECURSIVE_PREFIX))
 }
 
-func processJSONCredentials(jsonString, servicename, credkey string) (string, bool) {
-	if !gjson.Valid(jsonString) {
-		log.Errorln("Failed to apply JSONPath", jsonString)
+// deepSearch returns the value found for a gjson-style dotted key at any depth. When the key
+// matches more than once the shallowest match wins, ties going to the path that sorts first;
+// in strict mode more than one match is an error instead.
+func deepSearch(current gjson.Result, search string) (string, bool) {
+	matches := findKey(current, search, nil, nil)
+	if len(matches) == 0 {
 		return "", false
 	}
-	jsonObj := gjson.Parse(jsonString)
-	credArray := jsonObj.Get(PREFIX_PATTERN_USER)
-	ret, ok := "", false
-	credArray.ForEach(func(_, searchPattern gjson.Result) bool {
-		if searchPattern.Get("name").String() == servicename {
-			ret, ok = deepSearch(searchPattern, credkey)
-			return !ok
-		}
-		return true
-	})
-	return ret, ok
+	sortKeyMatches(matches)
+	if len(matches) > 1 && recursiveSearchStrict() {
+		log.Errorln(newAmbiguousKeyError(search, matches))
+		return "", false
+	}
+	return formatGJSON(matches[0].value)
 }
 
-func deepSearch(current gjson.Result, search string) (string, bool) {
+// processSelector applies a JSONPath, or a recursive key search when the selector starts
+// with .., to a JSON document
+func processSelector(jsonString string, selector string) (string, bool) {
+	return newJSONDocument(jsonString).selectValue(selector)
+}
+
+// jsonDocument holds a document validated once, decoding it for JSONPath on first use
+type jsonDocument struct {
+	valid   bool
+	result  gjson.Result
+	once    sync.Once
+	decoded interface{}
+	err     error
+}
 
-	res := current.Get(search).String()
-	ok := false
-	if current.Get(search).Exists() {
-		return res, true
+func newJSONDocument(jsonString string) *jsonDocument {
+	document := &jsonDocument{valid: gjson.Valid(jsonString)}
+	if document.valid {
+		document.result = gjson.Parse(jsonString)
 	}
+	return document
+}
 
-	_, isMap := current.Value().(map[string]interface{})
-	_, isArr := current.Value().([]interface{})
-	if isMap || isArr {
-		current.ForEach(func(_, v gjson.Result) bool {
-			res, ok = deepSearch(v, search)
-			return !ok
-		})
+func (d *jsonDocument) selectValue(selector string) (string, bool) {
+	if !d.valid {
+		return "", false
+	}
+	if strings.HasPrefix(selector, SELECTOR_RECURSIVE_PREFIX) {
+		return deepSearch(d.result, strings.TrimPrefix(selector, SELECTOR_RECURSIVE_PREFIX))
+	}
+	path, err := cachedJSONPath(selector)
+	if err != nil {
+		log.Errorln(err)
+		return "", false
+	}
+	d.once.Do(func() {
+		d.decoded, d.err = decodeJSONDocument(d.result.Raw)
+	})
+	if d.err != nil {
+		return "", false
 	}
-	return res, ok
+	return jsonPathResult(path, d.decoded)
 }
 
-func processJSONPath(jsonString string, jsonPath string) (string, bool) {
-	var json_data interface{}
-	err := json.Unmarshal([]byte(jsonString), &json_data)
+// formatGJSON formats a scalar the same way JSONPath results are formatted. Objects and
+// arrays are returned as written, so credentials objects keep their key order and spacing.
+func formatGJSON(result gjson.Result) (string, bool) {
+	if result.IsObject() || result.IsArray() {
+		return result.Raw, true
+	}
+	value, err := decodeJSONDocument(result.Raw)
 	if err != nil {
 		return "", false
 	}
-	res, err := jsonpath.JsonPathLookup(json_data, jsonPath)
-	_, isMap := res.(map[string]interface{})
-	_, isArr := res.([]interface{})
+	return formatJSONValue(value)
+}
 
-	if isMap || isArr {
-		test, _ := json.Marshal(res)
-		return string(test), err == nil
+// processJSONPath applies an RFC 9535 JSONPath, or one accepted by the compatibility mode,
+// to a JSON document
+func processJSONPath(jsonString string, jsonPath string) (string, bool) {
+	path, err := cachedJSONPath(jsonPath)
+	if err != nil {
+		log.Errorln(err)
+		return "", false
 	}
-	return fmt.Sprintf("%v", res), err == nil
+	document, err := decodeJSONDocument(jsonString)
+	if err != nil {
+		return "", false
+	}
+	return jsonPathResult(path, document)
 }
 
 func GetCredentialsForService(serviceTag, serviceLabel, credentials string) map[string]string {
@@ -281,6 +535,19 @@ func GetCredentialsForService(serviceTag, serviceLabel, credentials string) map[
 }
 
 func GetString(name string) (string, bool) {
+	return GetStringContext(context.Background(), name)
+}
+
+// GetStringContext is GetString resolving a lazy mapping within the deadline of ctx. A lazy
+// mapping whose resolution is canceled stays pending and is resolved by the next call.
+func GetStringContext(ctx context.Context, name string) (string, bool) {
+	resolveLazily(ctx, name)
+	return loadedString(name)
+}
+
+func loadedString(name string) (string, bool) {
+	mappingsMutex.RLock()
+	defer mappingsMutex.RUnlock()
 	val, ok := loadedMappings[name]
 	if !ok {
 		return "", false
@@ -295,7 +562,12 @@ func GetString(name string) (string, bool) {
 }
 
 func GetDictionary(name string) gjson.Result {
-	value, ok := GetString(name)
+	return GetDictionaryContext(context.Background(), name)
+}
+
+// GetDictionaryContext is GetDictionary resolving a lazy mapping within the deadline of ctx
+func GetDictionaryContext(ctx context.Context, name string) gjson.Result {
+	value, ok := GetStringContext(ctx, name)
 	if !ok {
 		log.Warnln(value + " does not exist")
 		return gjson.Parse("{\"value\": \"" + value + "\"}")
@@ -0,0 +1,182 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"net/url"
+	"strings"
+)
+
+// Connection types as found under "connection" in IBM Cloud Databases credentials
+const CONNECTION_POSTGRES = "postgres"
+const CONNECTION_MONGODB = "mongodb"
+const CONNECTION_REDIS = "rediss"
+const CONNECTION_ELASTICSEARCH = "https"
+const CONNECTION_RABBITMQ = "amqps"
+
+// Connection is a ready-to-use connection string together with the CA
+// certificate the server presents, if the credentials carry one.
+type Connection struct {
+	URI           string
+	CACertificate []byte
+}
+
+// GetConnection builds the connection string of the given type from a mapping holding
+// IBM Cloud Databases credentials. The mapping may contain the whole credentials object,
+// its "connection" object or the connection block itself. The composed string is preferred
+// and the string is assembled from scheme, authentication, hosts, path and query options
+// when it is missing.
+func GetConnection(name, connectionType string) (*Connection, error) {
+	value, ok := GetString(name)
+	if !ok {
+		return nil, fmt.Errorf("mapping %s does not exist", name)
+	}
+	block := findConnectionBlock(gjson.Parse(value), connectionType)
+	if !block.Exists() {
+		return nil, fmt.Errorf("mapping %s: no %s connection found", name, connectionType)
+	}
+
+	uri, err := composedConnectionString(block)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %v", name, err)
+	}
+	if uri == "" {
+		if uri, err = buildConnectionString(block, connectionType); err != nil {
+			return nil, fmt.Errorf("mapping %s: %v", name, err)
+		}
+	}
+
+	connection := &Connection{URI: uri}
+	if certificate := block.Get("certificate.certificate_base64"); certificate.String() != "" {
+		if connection.CACertificate, err = decodePEM(certificate.String()); err != nil {
+			return nil, fmt.Errorf("mapping %s: CA certificate: %v", name, err)
+		}
+	}
+	return connection, nil
+}
+
+// GetPostgresDSN returns a Postgres connection URI usable by lib/pq and pgx
+func GetPostgresDSN(name string) (string, error) {
+	return getConnectionURI(name, CONNECTION_POSTGRES)
+}
+
+// GetMongoDBURI returns a MongoDB connection URI listing every replica set member
+func GetMongoDBURI(name string) (string, error) {
+	return getConnectionURI(name, CONNECTION_MONGODB)
+}
+
+// GetRedisURI returns a rediss:// connection URI
+func GetRedisURI(name string) (string, error) {
+	return getConnectionURI(name, CONNECTION_REDIS)
+}
+
+// GetElasticsearchURL returns the https:// endpoint of an Elasticsearch deployment
+func GetElasticsearchURL(name string) (string, error) {
+	return getConnectionURI(name, CONNECTION_ELASTICSEARCH)
+}
+
+// GetRabbitMQURI returns an amqps:// connection URI
+func GetRabbitMQURI(name string) (string, error) {
+	return getConnectionURI(name, CONNECTION_RABBITMQ)
+}
+
+func getConnectionURI(name, connectionType string) (string, error) {
+	connection, err := GetConnection(name, connectionType)
+	if err != nil {
+		return "", err
+	}
+	return connection.URI, nil
+}
+
+func findConnectionBlock(credentials gjson.Result, connectionType string) gjson.Result {
+	for _, path := range []string{"connection." + connectionType, connectionType} {
+		if block := credentials.Get(path); block.IsObject() {
+			return block
+		}
+	}
+	if credentials.Get("hosts").Exists() || credentials.Get("composed").Exists() {
+		return credentials
+	}
+	return gjson.Result{}
+}
+
+// composedConnectionString returns the first composed string, with the $USERNAME and
+// $PASSWORD placeholders used by some credential formats filled in.
+func composedConnectionString(block gjson.Result) (string, error) {
+	composed := block.Get("composed")
+	if composed.IsArray() {
+		composed = composed.Get("0")
+	}
+	if !composed.Exists() || composed.String() == "" {
+		return "", nil
+	}
+	uri := composed.String()
+	if strings.Contains(uri, "$USERNAME") || strings.Contains(uri, "$PASSWORD") {
+		username := block.Get("authentication.username")
+		password := block.Get("authentication.password")
+		if !username.Exists() || !password.Exists() {
+			return "", fmt.Errorf("composed connection string has placeholders but no authentication")
+		}
+		uri = strings.Replace(uri, "$USERNAME", urlEscape(username.String()), -1)
+		uri = strings.Replace(uri, "$PASSWORD", urlEscape(password.String()), -1)
+	}
+	return uri, nil
+}
+
+func buildConnectionString(block gjson.Result, connectionType string) (string, error) {
+	hosts := []string{}
+	block.Get("hosts").ForEach(func(_, host gjson.Result) bool {
+		address := host.Get("hostname").String()
+		if port := host.Get("port"); port.Exists() {
+			address = fmt.Sprintf("%s:%d", address, port.Int())
+		}
+		hosts = append(hosts, address)
+		return true
+	})
+	if len(hosts) == 0 {
+		return "", fmt.Errorf("%s connection has neither a composed string nor hosts", connectionType)
+	}
+
+	scheme := block.Get("scheme").String()
+	if scheme == "" {
+		scheme = connectionType
+	}
+	uri := &url.URL{Scheme: scheme, Host: strings.Join(hosts, ",")}
+	if username := block.Get("authentication.username"); username.Exists() {
+		if password := block.Get("authentication.password"); password.Exists() {
+			uri.User = url.UserPassword(username.String(), password.String())
+		} else {
+			uri.User = url.User(username.String())
+		}
+	}
+
+	if path := block.Get("path"); path.Exists() {
+		uri.Path = path.String()
+	} else if database := block.Get("database"); database.Exists() {
+		uri.Path = "/" + database.String()
+	}
+
+	query := url.Values{}
+	block.Get("query_options").ForEach(func(key, value gjson.Result) bool {
+		query.Set(key.String(), value.String())
+		return true
+	})
+	uri.RawQuery = query.Encode()
+	return uri.String(), nil
+}
@@ -0,0 +1,171 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const PREFIX_PATTERN_CONSUL = "consul"
+
+// Consul connection, using the environment variables of the Consul CLI
+const CONSUL_HTTP_ADDR_ENV = "CONSUL_HTTP_ADDR"
+const CONSUL_HTTP_TOKEN_ENV = "CONSUL_HTTP_TOKEN"
+
+const CONSUL_DEFAULT_HTTP_ADDR = "http://127.0.0.1:8500"
+
+// consulWait is how long a blocking query waits for a change before Consul answers anyway
+var consulWait = "5m"
+
+// processConsulSearchPattern handles consul:key[:$.JSONPath]. A key ending with / reads every
+// key under it as a JSON object, nesting the keys on /.
+func processConsulSearchPattern(ctx context.Context, patternComponents []string) (string, bool) {
+	if len(patternComponents) < 2 || patternComponents[1] == "" {
+		return "", false
+	}
+	value, found, err := readConsulKey(ctx, patternComponents[1])
+	if err != nil {
+		log.Errorln("Failed to read Consul key", patternComponents[1], err)
+		return "", false
+	}
+	if !found {
+		return "", false
+	}
+	if len(patternComponents) == 3 {
+		return processSelector(value, patternComponents[2])
+	}
+	return value, true
+}
+
+func consulAddr() string {
+	addr := os.Getenv(CONSUL_HTTP_ADDR_ENV)
+	if addr == "" {
+		return CONSUL_DEFAULT_HTTP_ADDR
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimRight(addr, "/")
+}
+
+// readConsulKey reads a key or, for keys ending with /, a subtree, and records the index of
+// the read for watchConsulKey
+func readConsulKey(ctx context.Context, key string) (string, bool, error) {
+	addr := consulAddr()
+	value, found, index, err := queryConsul(ctx, kvClient, addr, key, 0)
+	if err == nil {
+		recordWatchIndex(PREFIX_PATTERN_CONSUL+"|"+addr+"|"+key, index)
+	}
+	return value, found, err
+}
+
+// watchConsulKey blocks until the key changes after the last read, using blocking queries
+func watchConsulKey(ctx context.Context, patternComponents []string) error {
+	if len(patternComponents) < 2 {
+		return fmt.Errorf("no key to watch")
+	}
+	addr, key := consulAddr(), patternComponents[1]
+	source := PREFIX_PATTERN_CONSUL + "|" + addr + "|" + key
+	index, ok := watchIndex(source)
+	if !ok {
+		if _, _, err := readConsulKey(ctx, key); err != nil {
+			return err
+		}
+		index, _ = watchIndex(source)
+	}
+	for {
+		_, _, next, err := queryConsul(ctx, kvWatchClient, addr, key, index)
+		if err != nil {
+			return err
+		} else if next == 0 {
+			// a query without an index does not block, so it must not be sent again right away
+			return fmt.Errorf("response has no X-Consul-Index")
+		}
+		if next > index {
+			return nil
+		}
+		// Consul answers with the same index when the wait time passed, and the index
+		// may go backwards when it was reset, in which case the watch starts over
+		if next < index {
+			index = 0
+		}
+	}
+}
+
+// queryConsul reads a key from the KV store, blocking until the index of the key moves past
+// index when index is not 0. It returns the value, whether the key exists and the new index.
+func queryConsul(ctx context.Context, client *http.Client, addr, key string, index uint64) (string, bool, uint64, error) {
+	query := url.Values{}
+	prefix := strings.HasSuffix(key, "/")
+	if prefix {
+		query.Set("recurse", "true")
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWait)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/kv/"+strings.TrimLeft(key, "/")+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", false, 0, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if token := os.Getenv(CONSUL_HTTP_TOKEN_ENV); token != "" {
+		request.Header.Set("X-Consul-Token", token)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", false, 0, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", false, 0, err
+	}
+	next, _ := strconv.ParseUint(response.Header.Get("X-Consul-Index"), 10, 64)
+	if response.StatusCode == http.StatusNotFound {
+		return "", false, next, nil
+	} else if response.StatusCode != http.StatusOK {
+		return "", false, next, fmt.Errorf("request failed with status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	values := make(map[string]string)
+	for _, entry := range gjson.ParseBytes(body).Array() {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Get("Value").String())
+		if err != nil {
+			return "", false, next, fmt.Errorf("value of %s is not valid base64", entry.Get("Key").String())
+		}
+		values[entry.Get("Key").String()] = string(decoded)
+	}
+	if !prefix {
+		value, found := values[strings.TrimLeft(key, "/")]
+		return value, found, next, nil
+	}
+	bytes, err := json.Marshal(subtree(values, strings.TrimLeft(key, "/")))
+	return string(bytes), true, next, err
+}
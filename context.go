@@ -0,0 +1,105 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IncompleteError reports the mappings left unresolved because the context was canceled or
+// its deadline passed. It unwraps to the context error.
+type IncompleteError struct {
+	Mappings []string
+	Err      error
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("resolution incomplete (%v), mappings not resolved: %s", e.Err, strings.Join(e.Mappings, ", "))
+}
+
+func (e *IncompleteError) Unwrap() error {
+	return e.Err
+}
+
+type incompleteKey struct{}
+
+// incompleteMappings collects the mappings a load aborted
+type incompleteMappings struct {
+	mutex sync.Mutex
+	names []string
+}
+
+func withIncompleteMappings(ctx context.Context) (context.Context, *incompleteMappings) {
+	incomplete := &incompleteMappings{}
+	return context.WithValue(ctx, incompleteKey{}, incomplete), incomplete
+}
+
+// markIncomplete records an unresolved mapping as incomplete when the context ended
+func markIncomplete(ctx context.Context, name string) {
+	if ctx.Err() == nil {
+		return
+	}
+	if incomplete, ok := ctx.Value(incompleteKey{}).(*incompleteMappings); ok {
+		incomplete.mutex.Lock()
+		defer incomplete.mutex.Unlock()
+		incomplete.names = append(incomplete.names, name)
+	}
+}
+
+// err returns an IncompleteError when mappings were aborted, or nil
+func (m *incompleteMappings) err(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.names) == 0 {
+		return nil
+	}
+	names := append([]string{}, m.names...)
+	sort.Strings(names)
+	return &IncompleteError{Mappings: names, Err: ctx.Err()}
+}
+
+// awaitSearchPattern processes a search pattern, giving up when the context ends first so
+// that a source that blocks, such as a file on a hung network mount, cannot hang resolution
+func awaitSearchPattern(ctx context.Context, mappingName, searchPattern string, options patternOptions) (string, bool) {
+	if ctx.Done() == nil {
+		return processSearchPattern(ctx, mappingName, searchPattern, options)
+	}
+	if ctx.Err() != nil {
+		return "", false
+	}
+	type result struct {
+		value string
+		ok    bool
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, ok := processSearchPattern(ctx, mappingName, searchPattern, options)
+		done <- result{value, ok}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.ok
+	case <-ctx.Done():
+		log.Warnln("Aborted searchPattern", redactPattern(searchPattern), "for mapping", mappingName+":", ctx.Err())
+		return "", false
+	}
+}
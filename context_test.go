@@ -0,0 +1,108 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInitializeContext(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	t.Setenv("CONTEXT_VAR1", "value1")
+	t.Setenv("CONTEXT_VAR2", "value2")
+
+	if _, err := InitializeContext(context.Background(), "server/config/context/mappings.json"); err != nil {
+		t.Errorf("Expected a complete load, got: %v\n", err)
+	}
+	testString, _ := GetString("context_template")
+	if testString != "value1-value2" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "value1-value2")
+	}
+}
+
+func TestInitializeContextCanceled(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	t.Setenv("CONTEXT_VAR1", "value1")
+	t.Setenv("CONTEXT_VAR2", "value2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := InitializeContext(ctx, "server/config/context/mappings.json")
+	var incomplete *IncompleteError
+	if !errors.As(err, &incomplete) {
+		t.Fatalf("Expected an IncompleteError, got: %v\n", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the error to unwrap to context.Canceled\n")
+	}
+	expected := []string{"context_template", "context_var1", "context_var2"}
+	if !reflect.DeepEqual(incomplete.Mappings, expected) {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", incomplete.Mappings, expected)
+	}
+	if mappingResolved("context_var2") {
+		t.Errorf("Expected context_var2 not to be resolved after cancellation\n")
+	}
+	if GetLoadStatus().LastError != err.Error() {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", GetLoadStatus().LastError, err.Error())
+	}
+}
+
+func TestAwaitSearchPatternDeadline(t *testing.T) {
+	t.Setenv("CONTEXT_VAR1", "value1")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if value, ok := awaitSearchPattern(ctx, "context_var1", "env:CONTEXT_VAR1", patternOptions{}); !ok || value != "value1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "value1")
+	}
+
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	if _, ok := awaitSearchPattern(expired, "context_var1", "env:CONTEXT_VAR1", patternOptions{}); ok {
+		t.Errorf("Expected an expired deadline to abort the search pattern\n")
+	}
+}
+
+func TestLazyResolutionContext(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	t.Setenv("LAZY_VAR1", "value1")
+	Initialize("server/config/lazy/mappings.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := GetStringContext(ctx, "lazy_var1"); ok {
+		t.Errorf("Expected a canceled context not to resolve lazy_var1\n")
+	}
+	if !lazyPendingMapping("lazy_var1") {
+		t.Errorf("Expected lazy_var1 to stay pending after cancellation\n")
+	}
+	err := PreloadContext(ctx, "lazy_var1")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected PreloadContext to report the cancellation, got: %v\n", err)
+	}
+	if _, err := GetServiceCredentialsContext[CloudantCredentials](ctx, "lazy_var1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetServiceCredentialsContext to report the cancellation, got: %v\n", err)
+	}
+
+	testString, _ := GetStringContext(context.Background(), "lazy_var1")
+	if testString != "value1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "value1")
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"strings"
+)
+
+const DEBUG_VALUE_REDACTED = "<redacted>"
+
+// DebugOptions configures DebugHandler. Values are always redacted unless HashValues is
+// set, in which case a SHA-256 hash of each value is shown so values can be compared across
+// instances without revealing them. Setting HashKey turns the hash into an HMAC, which stops
+// low entropy values from being guessed from their hash.
+type DebugOptions struct {
+	HashValues bool
+	HashKey    []byte
+}
+
+type debugMapping struct {
+	MappingStatus
+	Value string `json:"value,omitempty"`
+}
+
+type debugReport struct {
+	Platform string         `json:"platform"`
+	Load     LoadStatus     `json:"load"`
+	Mappings []debugMapping `json:"mappings"`
+}
+
+// DebugHandler returns an http.Handler reporting, as JSON, every mapping, whether it resolved,
+// the search pattern and source that won, the detected platform and the load timestamps.
+// It is meant to be mounted on an internal path such as /debug/ibmcloudenv.
+func DebugHandler(opts *DebugOptions) http.Handler {
+	options := DebugOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := debugReport{
+			Platform: Platform(),
+			Load:     GetLoadStatus(),
+			Mappings: []debugMapping{},
+		}
+		for _, status := range GetMappingStatuses() {
+			mapping := debugMapping{MappingStatus: status}
+			if status.Resolved {
+				mapping.Value = DEBUG_VALUE_REDACTED
+				if options.HashValues {
+					mapping.Value = hashValue(lookupStatusValue(status.Name), options.HashKey)
+				}
+			}
+			report.Mappings = append(report.Mappings, mapping)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	})
+}
+
+// lookupStatusValue returns the value of a mapping, including "mapping.key" entries of version 2 files
+func lookupStatusValue(name string) string {
+	if value, ok := GetString(name); ok {
+		return value
+	}
+	index := strings.LastIndex(name, ".")
+	if index < 0 {
+		return ""
+	}
+	if value, ok := GetString(name[:index]); ok {
+		return gjson.Get(value, escapeGJSONPath(name[index+1:])).String()
+	}
+	return ""
+}
+
+func hashValue(value string, key []byte) string {
+	if len(key) > 0 {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(value))
+		return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func escapeGJSONPath(key string) string {
+	replacer := strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`)
+	return replacer.Replace(key)
+}
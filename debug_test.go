@@ -0,0 +1,131 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getDebugReport(t *testing.T, opts *DebugOptions) gjson.Result {
+	recorder := httptest.NewRecorder()
+	DebugHandler(opts).ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/ibmcloudenv", nil))
+	if recorder.Code != 200 || !gjson.Valid(recorder.Body.String()) {
+		t.Fatalf("Unexpected response %d: %s\n", recorder.Code, recorder.Body.String())
+	}
+	return gjson.Parse(recorder.Body.String())
+}
+
+func TestDebugHandlerRedactsValues(t *testing.T) {
+	setEnvVariable()
+	report := getDebugReport(t, nil)
+
+	if report.Get("platform").String() != Platform() || report.Get("load.loads").Int() == 0 {
+		t.Errorf("Unexpected report: %s\n", report.Raw)
+	}
+	envVar := report.Get(`mappings.#(name=="env_var1")`)
+	if !envVar.Get("resolved").Bool() || envVar.Get("pattern").String() != "env:ENV_VAR_STRING" ||
+		envVar.Get("source").String() != PREFIX_PATTERN_ENV || envVar.Get("value").String() != DEBUG_VALUE_REDACTED {
+		t.Errorf("Unexpected mapping status: %s\n", envVar.Raw)
+	}
+	badVar := report.Get(`mappings.#(name=="bad_var3")`)
+	if !badVar.Exists() || badVar.Get("resolved").Bool() || badVar.Get("value").Exists() {
+		t.Errorf("Unexpected mapping status: %s\n", badVar.Raw)
+	}
+	if strings.Contains(report.Raw, var_string) {
+		t.Errorf("Report contains an unredacted value\n")
+	}
+}
+
+func TestDebugHandlerHashesValues(t *testing.T) {
+	setEnvVariable()
+	report := getDebugReport(t, &DebugOptions{HashValues: true})
+	value := report.Get(`mappings.#(name=="env_var1").value`).String()
+	if value != hashValue(var_string, nil) || !strings.HasPrefix(value, "sha256:") {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, hashValue(var_string, nil))
+	}
+
+	keyed := getDebugReport(t, &DebugOptions{HashValues: true, HashKey: []byte("key")})
+	value = keyed.Get(`mappings.#(name=="env_var1").value`).String()
+	if value != hashValue(var_string, []byte("key")) || !strings.HasPrefix(value, "hmac-sha256:") {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, hashValue(var_string, []byte("key")))
+	}
+}
+
+func TestDebugHandlerVersion2Mappings(t *testing.T) {
+	setEnvVariableV2()
+	report := getDebugReport(t, &DebugOptions{HashValues: true})
+	value := report.Get(`mappings.#(name=="var1.env_var1").value`).String()
+	if value != hashValue(var_stringV2, nil) {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, hashValue(var_stringV2, nil))
+	}
+}
+
+func TestDebugHandlerAfterReload(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	setEnvVariable()
+	Initialize("server/config/template/mappings.json")
+	report := getDebugReport(t, nil)
+	if report.Get(`mappings.#(name=="bad_var3")`).Exists() || report.Get(`mappings.#(name=="env_var1")`).Exists() {
+		t.Errorf("Report contains mappings of the previous mappings file: %s\n", report.Get("mappings").Raw)
+	}
+	if len(report.Get("mappings").Array()) == 0 {
+		t.Errorf("Report contains no mappings of the current mappings file\n")
+	}
+}
+
+func TestDebugHandlerLoadErrorCleared(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	Initialize("server/config/missing/mappings.json")
+	if report := getDebugReport(t, nil); report.Get("load.lastError").String() == "" {
+		t.Errorf("Expected the failed load to be reported: %s\n", report.Get("load").Raw)
+	}
+	Initialize("server/config/mappings.json")
+	if report := getDebugReport(t, nil); report.Get("load.lastError").Exists() || GetLoadStatus().LastError != "" {
+		t.Errorf("Expected the error to be cleared by a successful load: %s\n", report.Get("load").Raw)
+	}
+}
+
+func TestDebugHandlerKeepsSelectors(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	server := newHTTPStandIn(t)
+	t.Setenv("VCAP_SERVICES", `{"cloudantNoSQLDB": [{"name": "x", "credentials": {"url": "https://cloudant.example.com"}}]}`)
+	filter := "cloudfoundry:$.cloudantNoSQLDB[?@.name=='x'].credentials.url"
+	query := strings.Replace(server.URL, "http://", "http://u:p@", 1) + "/flaky?token=1:$.status"
+	mappingsFile := t.TempDir() + "/mappings.json"
+	ioutil.WriteFile(mappingsFile, []byte(`{
+		"debug_filter": {"searchPatterns": ["`+filter+`"]},
+		"debug_query": {"searchPatterns": ["`+query+`"]}
+	}`), 0644)
+	Initialize(mappingsFile)
+
+	report := getDebugReport(t, nil)
+	for name, expected := range map[string]string{
+		"debug_filter": filter,
+		"debug_query":  "http:" + strings.TrimPrefix(server.URL, "http:") + "/flaky:$.status",
+	} {
+		if pattern := report.Get(`mappings.#(name=="` + name + `").pattern`).String(); pattern != expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", name, pattern, expected)
+		}
+	}
+	if strings.Contains(report.Raw, "u:p@") || strings.Contains(report.Raw, "token=") {
+		t.Errorf("Report contains the credentials of the URL: %s\n", report.Get("mappings").Raw)
+	}
+}
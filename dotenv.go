@@ -0,0 +1,272 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+const PREFIX_PATTERN_DOTENV = "dotenv"
+
+// processDotenvSearchPattern handles dotenv:/path/.env[:KEY]. Without a key the whole
+// file is returned as a JSON object.
+func processDotenvSearchPattern(patternComponents []string) (string, bool) {
+	if len(patternComponents) < 2 {
+		return "", false
+	}
+	content, ok := readProjectFile(patternComponents[1])
+	if !ok {
+		return "", false
+	}
+	values, err := parseDotenv(string(content))
+	if err != nil {
+		log.Errorln("Failed to parse dotenv file", patternComponents[1], err)
+		return "", false
+	}
+	if len(patternComponents) == 3 {
+		value, ok := values[patternComponents[2]]
+		return value, ok
+	}
+	document, err := json.Marshal(values)
+	if err != nil {
+		return "", false
+	}
+	return string(document), true
+}
+
+// parseDotenv parses dotenv syntax: comments, optional export, single quoted literal
+// values, double quoted values with escapes, both of which may span lines, and
+// $VAR, ${VAR}, ${VAR:-default} and ${VAR-default} expansion in unquoted and double
+// quoted values. Variables are looked up in the file first, then in the environment.
+func parseDotenv(src string) (map[string]string, error) {
+	parser := dotenvParser{src: src, line: 1, values: make(map[string]string)}
+	for {
+		parser.skip(" \t\r\n")
+		if parser.eof() {
+			return parser.values, nil
+		}
+		if parser.peek() == '#' {
+			parser.skipLine()
+			continue
+		}
+		if err := parser.assignment(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", parser.line, err)
+		}
+	}
+}
+
+type dotenvParser struct {
+	src    string
+	pos    int
+	line   int
+	values map[string]string
+}
+
+func (p *dotenvParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *dotenvParser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *dotenvParser) skip(chars string) {
+	for !p.eof() && strings.IndexByte(chars, p.peek()) >= 0 {
+		if p.peek() == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+func (p *dotenvParser) key() string {
+	start := p.pos
+	for !p.eof() && isDotenvKeyChar(p.peek()) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *dotenvParser) assignment() error {
+	key := p.key()
+	if key == "export" && !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.skip(" \t")
+		key = p.key()
+	}
+	if key == "" {
+		return fmt.Errorf("unexpected character %q", p.peek())
+	}
+	p.skip(" \t")
+	if p.eof() || p.peek() != '=' {
+		return fmt.Errorf("expected = after %s", key)
+	}
+	p.pos++
+	p.skip(" \t")
+
+	var value string
+	var err error
+	switch {
+	case p.eof():
+	case p.peek() == '\'':
+		value, err = p.quoted('\'')
+	case p.peek() == '"':
+		value, err = p.quoted('"')
+		value = p.expand(value, true)
+	default:
+		start := p.pos
+		p.skipLine()
+		raw := p.src[start:p.pos]
+		if index := strings.Index(raw, " #"); index >= 0 {
+			raw = raw[:index]
+		}
+		if index := strings.Index(raw, "\t#"); index >= 0 {
+			raw = raw[:index]
+		}
+		value = p.expand(strings.TrimSpace(raw), false)
+	}
+	if err != nil {
+		return err
+	}
+	p.values[key] = value
+	return p.endOfLine()
+}
+
+// quoted returns the raw content up to the closing quote, skipping escaped quotes
+// inside double quoted values
+func (p *dotenvParser) quoted(quote byte) (string, error) {
+	line := p.line
+	p.pos++
+	start := p.pos
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == quote:
+			p.pos++
+			return p.src[start : p.pos-1], nil
+		case c == '\\' && quote == '"' && p.pos+1 < len(p.src):
+			p.pos++
+		case c == '\n':
+			p.line++
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("unterminated quoted value starting on line %d", line)
+}
+
+func (p *dotenvParser) endOfLine() error {
+	p.skip(" \t\r")
+	if p.eof() || p.peek() == '\n' {
+		return nil
+	}
+	if p.peek() == '#' {
+		p.skipLine()
+		return nil
+	}
+	return fmt.Errorf("unexpected character %q after value", p.peek())
+}
+
+// expand replaces variable references; with escapes the backslash sequences of
+// double quoted values are processed too, otherwise only \$ is
+func (p *dotenvParser) expand(raw string, escapes bool) string {
+	var result strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\\' && i+1 < len(raw) && (escapes || raw[i+1] == '$'):
+			i++
+			switch raw[i] {
+			case 'n':
+				result.WriteByte('\n')
+			case 'r':
+				result.WriteByte('\r')
+			case 't':
+				result.WriteByte('\t')
+			case '"', '\\', '$':
+				result.WriteByte(raw[i])
+			default:
+				result.WriteByte('\\')
+				result.WriteByte(raw[i])
+			}
+		case c == '$':
+			value, length := p.reference(raw[i+1:])
+			if length == 0 {
+				result.WriteByte(c)
+				continue
+			}
+			result.WriteString(value)
+			i += length
+		default:
+			result.WriteByte(c)
+		}
+	}
+	return result.String()
+}
+
+// reference resolves the variable reference following a $ and returns its value and length
+func (p *dotenvParser) reference(raw string) (string, int) {
+	if strings.HasPrefix(raw, "{") {
+		end := strings.IndexByte(raw, '}')
+		if end < 0 {
+			return "", 0
+		}
+		name, fallback := raw[1:end], ""
+		emptyFallback, hasFallback := false, false
+		if index := strings.Index(name, ":-"); index >= 0 {
+			name, fallback, emptyFallback, hasFallback = name[:index], name[index+2:], true, true
+		} else if index := strings.IndexByte(name, '-'); index >= 0 {
+			name, fallback, hasFallback = name[:index], name[index+1:], true
+		}
+		value, found := p.lookup(name)
+		if hasFallback && (!found || (emptyFallback && value == "")) {
+			value = fallback
+		}
+		return value, end + 1
+	}
+	length := 0
+	for length < len(raw) && isDotenvNameChar(raw[length], length == 0) {
+		length++
+	}
+	if length == 0 {
+		return "", 0
+	}
+	value, _ := p.lookup(raw[:length])
+	return value, length
+}
+
+func (p *dotenvParser) lookup(name string) (string, bool) {
+	if value, ok := p.values[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+func isDotenvKeyChar(c byte) bool {
+	return isDotenvNameChar(c, false) || c == '.' || c == '-'
+}
+
+func isDotenvNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
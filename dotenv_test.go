@@ -0,0 +1,104 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"testing"
+)
+
+func TestDotenvFile(t *testing.T) {
+	t.Setenv("DOTENV_FALLBACK", "fallback")
+	Initialize("server/config/dotenv/mappings.json")
+
+	testString, _ := GetString("dotenv_var1")
+	if testString != "postgres://admin@localhost:5432/app" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "postgres://admin@localhost:5432/app")
+	}
+	testString = GetDictionary("dotenv_var2").Get("DB_HOST").String()
+	if testString != "localhost" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "localhost")
+	}
+	testString, _ = GetString("dotenv_var3")
+	if testString != "fallback" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "fallback")
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	t.Setenv("DOTENV_FROM_ENV", "from-env")
+	values, err := parseDotenv(`
+# comment
+PLAIN=value
+export EXPORTED = spaced value  # comment
+EMPTY=
+HASH=value#not-a-comment
+SINGLE='literal $PLAIN \n'
+DOUBLE="tab\there \"quoted\" \$PLAIN"
+MULTILINE="first
+second"
+SINGLE_MULTILINE='a
+b' # comment
+EXPANDED=$PLAIN-${EXPORTED}
+FROM_ENV=$DOTENV_FROM_ENV
+DEFAULT=${UNSET_DOTENV_VAR:-default}
+EMPTY_DEFAULT=${EMPTY:-default}
+SET_EMPTY=${EMPTY-default}
+ESCAPED=\$PLAIN
+dotted.key-name=ok
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"PLAIN":            "value",
+		"EXPORTED":         "spaced value",
+		"EMPTY":            "",
+		"HASH":             "value#not-a-comment",
+		"SINGLE":           `literal $PLAIN \n`,
+		"DOUBLE":           "tab\there \"quoted\" $PLAIN",
+		"MULTILINE":        "first\nsecond",
+		"SINGLE_MULTILINE": "a\nb",
+		"EXPANDED":         "value-spaced value",
+		"FROM_ENV":         "from-env",
+		"DEFAULT":          "default",
+		"EMPTY_DEFAULT":    "default",
+		"SET_EMPTY":        "",
+		"ESCAPED":          "$PLAIN",
+		"dotted.key-name":  "ok",
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("%s Got: \t%q\n Wanted: \t%q\n", key, values[key], value)
+		}
+	}
+	if len(values) != len(expected) {
+		t.Errorf("Got %d values, wanted %d\n", len(values), len(expected))
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	for _, src := range []string{
+		"NO_VALUE",
+		"UNTERMINATED=\"value",
+		"QUOTED='value' trailing",
+		"=value",
+	} {
+		if _, err := parseDotenv(src); err == nil {
+			t.Errorf("Expected an error parsing %q\n", src)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const PREFIX_PATTERN_ENCFILE = "encfile"
+
+// Keys used to decrypt encfile: search patterns, either inline or as a path to a key file
+const ENCFILE_AGE_KEY_ENV = "IBM_CLOUD_ENV_AGE_KEY"
+const ENCFILE_AGE_KEY_FILE_ENV = "IBM_CLOUD_ENV_AGE_KEY_FILE"
+const ENCFILE_AES_KEY_ENV = "IBM_CLOUD_ENV_AES_KEY"
+const ENCFILE_AES_KEY_FILE_ENV = "IBM_CLOUD_ENV_AES_KEY_FILE"
+
+const ageHeader = "age-encryption.org/v1"
+
+// processEncryptedFileSearchPattern handles encfile:/path/file[:$.JSONPath]. The file is
+// decrypted with age when it has an age header and with AES-256-GCM otherwise, then treated
+// the same way as the file: prefix treats plaintext files.
+func processEncryptedFileSearchPattern(patternComponents []string) (string, bool) {
+	if len(patternComponents) < 2 {
+		return "", false
+	}
+	content, ok := readProjectFile(patternComponents[1])
+	if !ok {
+		return "", false
+	}
+	plaintext, err := decryptFile(content)
+	if err != nil {
+		log.Errorln("Failed to decrypt", patternComponents[1], err)
+		return "", false
+	}
+	if len(patternComponents) == 3 {
+		return processSelector(string(plaintext), patternComponents[2])
+	}
+	return string(plaintext), true
+}
+
+func decryptFile(content []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(content)
+	if bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		return decryptAge(armor.NewReader(bytes.NewReader(trimmed)))
+	}
+	if bytes.HasPrefix(content, []byte(ageHeader)) {
+		return decryptAge(bytes.NewReader(content))
+	}
+	key, err := readKey(ENCFILE_AES_KEY_ENV, ENCFILE_AES_KEY_FILE_ENV)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptAESGCM(key, content)
+}
+
+func decryptAge(src io.Reader) ([]byte, error) {
+	key, err := readKey(ENCFILE_AGE_KEY_ENV, ENCFILE_AGE_KEY_FILE_ENV)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(key))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// readKey returns the key held by the inline environment variable or, failing that,
+// the content of the key file named by the file environment variable
+func readKey(inlineEnv, fileEnv string) ([]byte, error) {
+	if key, ok := os.LookupEnv(inlineEnv); ok && key != "" {
+		return []byte(key), nil
+	}
+	if path, ok := os.LookupEnv(fileEnv); ok && path != "" {
+		return ioutil.ReadFile(path)
+	}
+	return nil, fmt.Errorf("no key configured, set %s or %s", inlineEnv, fileEnv)
+}
+
+// EncryptAESGCM encrypts plaintext for the encfile: prefix. The key is 32 bytes encoded
+// as base64 or hex, the same as IBM_CLOUD_ENV_AES_KEY; the result is the base64
+// encoding of the random nonce followed by the sealed plaintext.
+func EncryptAESGCM(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// DecryptAESGCM reverses EncryptAESGCM. Raw nonce and ciphertext bytes are accepted as well.
+func DecryptAESGCM(key, content []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := content
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content))); err == nil {
+		sealed = decoded
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted content is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(encodedKey []byte) (cipher.AEAD, error) {
+	text := strings.TrimSpace(string(encodedKey))
+	key, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(key) != 32 {
+		if key, err = hex.DecodeString(text); err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("AES key must be 32 bytes encoded as base64 or hex")
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,116 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const encfileCredentials = `{"username": "enc-username", "password": "enc-password"}`
+
+func ageEncrypt(t *testing.T, recipient age.Recipient, armored bool) []byte {
+	var out bytes.Buffer
+	var dst io.WriteCloser = nopCloser{&out}
+	if armored {
+		dst = armor.NewWriter(&out)
+	}
+	writer, err := age.Encrypt(dst, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(writer, encfileCredentials)
+	writer.Close()
+	dst.Close()
+	return out.Bytes()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func setEncryptedFiles(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	t.Setenv(ENCFILE_AGE_KEY_ENV, identity.String())
+	t.Setenv(ENCFILE_AES_KEY_ENV, aesKey)
+	t.Setenv("ENCFILE_FALLBACK", "fallback")
+
+	os.MkdirAll("test/cases/encfile", 0755)
+	t.Cleanup(func() { os.RemoveAll("test/cases/encfile") })
+	ioutil.WriteFile("test/cases/encfile/credentials.json.age", ageEncrypt(t, identity.Recipient(), false), 0644)
+	ioutil.WriteFile("test/cases/encfile/credentials.json.age.asc", ageEncrypt(t, identity.Recipient(), true), 0644)
+
+	encrypted, err := EncryptAESGCM([]byte(aesKey), []byte(encfileCredentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile("test/cases/encfile/credentials.json.enc", encrypted, 0644)
+	tampered, _ := base64.StdEncoding.DecodeString(string(encrypted))
+	tampered[len(tampered)-1] ^= 1
+	ioutil.WriteFile("test/cases/encfile/tampered.json.enc", []byte(base64.StdEncoding.EncodeToString(tampered)), 0644)
+
+	Initialize("server/config/encfile/mappings.json")
+}
+
+func TestAgeEncryptedFile(t *testing.T) {
+	setEncryptedFiles(t)
+	testString, _ := GetString("encfile_var1")
+	if testString != "enc-username" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "enc-username")
+	}
+	testString = GetDictionary("encfile_var2").Get("password").String()
+	if testString != "enc-password" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "enc-password")
+	}
+}
+
+func TestAESGCMEncryptedFile(t *testing.T) {
+	setEncryptedFiles(t)
+	testString, _ := GetString("encfile_var3")
+	if testString != "enc-password" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "enc-password")
+	}
+	testString, _ = GetString("encfile_var4")
+	if testString != "fallback" {
+		t.Errorf("Tampered file should not decrypt, got: %s\n", testString)
+	}
+}
+
+func TestEncryptedFileWithoutKey(t *testing.T) {
+	setEncryptedFiles(t)
+	os.Unsetenv(ENCFILE_AES_KEY_ENV)
+	if _, ok := processEncryptedFileSearchPattern([]string{PREFIX_PATTERN_ENCFILE, "/test/cases/encfile/credentials.json.enc"}); ok {
+		t.Errorf("Expected decryption to fail without a key\n")
+	}
+
+	keyFile := t.TempDir() + "/aes.key"
+	ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600)
+	t.Setenv(ENCFILE_AES_KEY_FILE_ENV, keyFile)
+	if value, ok := processEncryptedFileSearchPattern([]string{PREFIX_PATTERN_ENCFILE, "/test/cases/encfile/credentials.json.enc", "$.username"}); !ok || value != "enc-username" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "enc-username")
+	}
+}
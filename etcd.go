@@ -0,0 +1,227 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const PREFIX_PATTERN_ETCD = "etcd"
+
+// etcd connection, using the environment variables of etcdctl. ETCDCTL_ENDPOINTS is a comma
+// separated list of endpoints tried in order and ETCDCTL_USER is name:password.
+const ETCD_ENDPOINTS_ENV = "ETCDCTL_ENDPOINTS"
+const ETCD_USER_ENV = "ETCDCTL_USER"
+
+const ETCD_DEFAULT_ENDPOINT = "http://127.0.0.1:2379"
+
+// processEtcdSearchPattern handles etcd:key[:$.JSONPath]. A key ending with / reads every key
+// under it as a JSON object, nesting the keys on /.
+func processEtcdSearchPattern(ctx context.Context, patternComponents []string) (string, bool) {
+	if len(patternComponents) < 2 || patternComponents[1] == "" {
+		return "", false
+	}
+	value, found, err := readEtcdKey(ctx, patternComponents[1])
+	if err != nil {
+		log.Errorln("Failed to read etcd key", patternComponents[1], err)
+		return "", false
+	}
+	if !found {
+		return "", false
+	}
+	if len(patternComponents) == 3 {
+		return processSelector(value, patternComponents[2])
+	}
+	return value, true
+}
+
+func etcdEndpoints() []string {
+	endpoints := []string{}
+	for _, endpoint := range strings.Split(os.Getenv(ETCD_ENDPOINTS_ENV), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint == "" {
+			continue
+		} else if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		endpoints = append(endpoints, strings.TrimRight(endpoint, "/"))
+	}
+	if len(endpoints) == 0 {
+		return []string{ETCD_DEFAULT_ENDPOINT}
+	}
+	return endpoints
+}
+
+// etcdRange returns the range of a key for the etcd API, covering every key starting with
+// it when the key ends with /
+func etcdRange(key string) map[string]string {
+	keyRange := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(key))}
+	if strings.HasSuffix(key, "/") {
+		end := []byte(key)
+		end[len(end)-1]++
+		keyRange["range_end"] = base64.StdEncoding.EncodeToString(end)
+	}
+	return keyRange
+}
+
+// readEtcdKey reads a key or, for keys ending with /, a subtree through the JSON gateway of
+// the first endpoint that answers, and records the revision of the read for watchEtcdKey
+func readEtcdKey(ctx context.Context, key string) (string, bool, error) {
+	var lastErr error
+	for _, endpoint := range etcdEndpoints() {
+		response, err := etcdRequest(ctx, kvClient, endpoint, "kv/range", etcdRange(key))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return "", false, err
+		}
+		result := gjson.ParseBytes(body)
+		recordWatchIndex(PREFIX_PATTERN_ETCD+"|"+key, uint64(result.Get("header.revision").Int()))
+
+		values := make(map[string]string)
+		for _, kv := range result.Get("kvs").Array() {
+			name, err := base64.StdEncoding.DecodeString(kv.Get("key").String())
+			if err != nil {
+				return "", false, fmt.Errorf("response contains an invalid key")
+			}
+			value, err := base64.StdEncoding.DecodeString(kv.Get("value").String())
+			if err != nil {
+				return "", false, fmt.Errorf("value of %s is not valid base64", name)
+			}
+			values[string(name)] = string(value)
+		}
+		if !strings.HasSuffix(key, "/") {
+			value, found := values[key]
+			return value, found, nil
+		}
+		if len(values) == 0 {
+			return "", false, nil
+		}
+		bytes, err := json.Marshal(subtree(values, key))
+		return string(bytes), true, err
+	}
+	return "", false, lastErr
+}
+
+// watchEtcdKey blocks until the key changes after the last read, using a watch stream
+func watchEtcdKey(ctx context.Context, patternComponents []string) error {
+	if len(patternComponents) < 2 {
+		return fmt.Errorf("no key to watch")
+	}
+	key := patternComponents[1]
+	revision, ok := watchIndex(PREFIX_PATTERN_ETCD + "|" + key)
+	if !ok {
+		if _, _, err := readEtcdKey(ctx, key); err != nil {
+			return err
+		}
+		revision, _ = watchIndex(PREFIX_PATTERN_ETCD + "|" + key)
+	}
+	create := etcdRange(key)
+	create["start_revision"] = fmt.Sprint(revision + 1)
+
+	var lastErr error
+	for _, endpoint := range etcdEndpoints() {
+		response, err := etcdRequest(ctx, kvWatchClient, endpoint, "watch", map[string]interface{}{"create_request": create})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		defer response.Body.Close()
+		decoder := json.NewDecoder(response.Body)
+		for {
+			var message json.RawMessage
+			if err := decoder.Decode(&message); err != nil {
+				return fmt.Errorf("watch stream ended: %v", err)
+			}
+			if result := gjson.GetBytes(message, "result"); len(result.Get("events").Array()) > 0 {
+				return nil
+			} else if result.Get("canceled").Bool() {
+				return fmt.Errorf("watch canceled: %s", result.Get("cancel_reason").String())
+			} else if gjson.GetBytes(message, "error").Exists() {
+				return fmt.Errorf("watch failed: %s", gjson.GetBytes(message, "error.message").String())
+			}
+		}
+	}
+	return lastErr
+}
+
+// etcdRequest posts a request to the JSON gateway of the etcd v3 API, authenticating first
+// when ETCDCTL_USER is set. A response with another status than 200 is returned as an error.
+func etcdRequest(ctx context.Context, client *http.Client, endpoint, path string, body interface{}) (*http.Response, error) {
+	token := ""
+	if user := os.Getenv(ETCD_USER_ENV); user != "" {
+		name, password := user, ""
+		if index := strings.Index(user, ":"); index >= 0 {
+			name, password = user[:index], user[index+1:]
+		}
+		response, err := etcdPost(ctx, kvClient, endpoint, "auth/authenticate", "", map[string]string{"name": name, "password": password})
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if token = gjson.GetBytes(content, "token").String(); token == "" {
+			return nil, fmt.Errorf("authentication response contains no token")
+		}
+	}
+	return etcdPost(ctx, client, endpoint, path, token, body)
+}
+
+func etcdPost(ctx context.Context, client *http.Client, endpoint, path, token string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v3/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		content, _ := ioutil.ReadAll(response.Body)
+		message := gjson.GetBytes(content, "message").String()
+		if message == "" {
+			message = http.StatusText(response.StatusCode)
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", response.StatusCode, message)
+	}
+	return response, nil
+}
@@ -0,0 +1,179 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/magiconair/properties"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"strings"
+)
+
+// Formats understood by the file: prefix when a JSONPath is given
+const FILE_FORMAT_JSON = "json"
+const FILE_FORMAT_YAML = "yaml"
+const FILE_FORMAT_TOML = "toml"
+const FILE_FORMAT_INI = "ini"
+const FILE_FORMAT_PROPERTIES = "properties"
+
+// fileFormat returns the format hint when there is one, otherwise the format matching
+// the file extension. Files with unknown extensions are treated as JSON.
+func fileFormat(path, hint string) string {
+	format := strings.ToLower(hint)
+	if format == "" {
+		format = strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	}
+	switch format {
+	case "yaml", "yml":
+		return FILE_FORMAT_YAML
+	case FILE_FORMAT_TOML, FILE_FORMAT_INI, FILE_FORMAT_PROPERTIES:
+		return format
+	default:
+		return FILE_FORMAT_JSON
+	}
+}
+
+// convertToJSON converts a YAML, TOML, INI or properties document into JSON so that
+// JSONPath can be applied to it. JSON documents are returned unchanged.
+func convertToJSON(content []byte, format string) (string, error) {
+	var document interface{}
+	switch format {
+	case FILE_FORMAT_JSON:
+		return string(content), nil
+	case FILE_FORMAT_YAML:
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return "", err
+		}
+	case FILE_FORMAT_TOML:
+		values := make(map[string]interface{})
+		if err := toml.Unmarshal(content, &values); err != nil {
+			return "", err
+		}
+		document = values
+	case FILE_FORMAT_INI:
+		values, err := iniDocument(content)
+		if err != nil {
+			return "", err
+		}
+		document = values
+	case FILE_FORMAT_PROPERTIES:
+		loader := properties.Loader{Encoding: properties.UTF8, DisableExpansion: true}
+		props, err := loader.LoadBytes(content)
+		if err != nil {
+			return "", err
+		}
+		document = nestKeys(props.Map(), ".")
+	default:
+		return "", fmt.Errorf("unsupported file format %s", format)
+	}
+	converted, err := json.Marshal(normalizeDocument(document))
+	if err != nil {
+		return "", err
+	}
+	return string(converted), nil
+}
+
+// iniDocument returns the keys of the default section at the top level and every
+// other section as an object
+func iniDocument(content []byte) (map[string]interface{}, error) {
+	file, err := ini.Load(content)
+	if err != nil {
+		return nil, err
+	}
+	document := make(map[string]interface{})
+	for _, section := range file.Sections() {
+		if section.Name() == ini.DefaultSection {
+			for key, value := range section.KeysHash() {
+				document[key] = value
+			}
+			continue
+		}
+		values := make(map[string]interface{})
+		for key, value := range section.KeysHash() {
+			values[key] = value
+		}
+		document[section.Name()] = values
+	}
+	return document, nil
+}
+
+// nestKeys turns keys such as dotted property keys into nested objects, so db.host can be
+// found with $.db.host. Keys are visited in order so db comes before db.host; keys that
+// clash with such a value are kept as they are at the top level.
+func nestKeys(values map[string]string, separator string) map[string]interface{} {
+	document := make(map[string]interface{})
+	var clashes []string
+	for _, key := range sortedKeys(values) {
+		parts := strings.Split(key, separator)
+		current := document
+		nested := true
+		for _, part := range parts[:len(parts)-1] {
+			next, exists := current[part]
+			if !exists {
+				next = make(map[string]interface{})
+				current[part] = next
+			}
+			if current, nested = next.(map[string]interface{}); !nested {
+				break
+			}
+		}
+		if !nested {
+			clashes = append(clashes, key)
+			continue
+		}
+		current[parts[len(parts)-1]] = values[key]
+	}
+	for _, key := range clashes {
+		document[key] = values[key]
+	}
+	return document
+}
+
+// normalizeDocument converts the map[interface{}]interface{} values YAML produces for
+// non-string keys into objects JSON can represent
+func normalizeDocument(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeDocument(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = normalizeDocument(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeDocument(item)
+		}
+		return v
+	case []map[string]interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = normalizeDocument(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"testing"
+)
+
+func TestFileFormats(t *testing.T) {
+	t.Setenv("FILE_FORMAT_FALLBACK", "fallback")
+	Initialize("server/config/file_format/mappings.json")
+
+	for name, expected := range map[string]string{
+		"yaml_var":       "replica-2",
+		"toml_var":       "toml-host",
+		"ini_var":        "ini-host",
+		"properties_var": "jdbc:postgresql://${db.host}/app",
+		"hinted_var":     "conf-host",
+		"unhinted_var":   "fallback",
+	} {
+		testString, _ := GetString(name)
+		if testString != expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", name, testString, expected)
+		}
+	}
+}
+
+func TestFileFormat(t *testing.T) {
+	for _, test := range []struct {
+		path, hint, expected string
+	}{
+		{"/config.json", "", FILE_FORMAT_JSON},
+		{"/config.yml", "", FILE_FORMAT_YAML},
+		{"/config.YAML", "", FILE_FORMAT_YAML},
+		{"/config.toml", "", FILE_FORMAT_TOML},
+		{"/config.ini", "", FILE_FORMAT_INI},
+		{"/config.properties", "", FILE_FORMAT_PROPERTIES},
+		{"/config.txt", "", FILE_FORMAT_JSON},
+		{"/config.json", "toml", FILE_FORMAT_TOML},
+	} {
+		if actual := fileFormat(test.path, test.hint); actual != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.path, actual, test.expected)
+		}
+	}
+}
+
+func TestConvertToJSON(t *testing.T) {
+	converted, err := convertToJSON([]byte("db.host=a\ndb=b\ndb.port=1\nname=c"), FILE_FORMAT_PROPERTIES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"db":"b","db.host":"a","db.port":"1","name":"c"}`
+	if converted != expected {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", converted, expected)
+	}
+
+	converted, err = convertToJSON([]byte("1: one\ntrue: yes"), FILE_FORMAT_YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"1":"one","true":"yes"}`
+	if converted != expected {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", converted, expected)
+	}
+
+	if _, err := convertToJSON([]byte("key = "), FILE_FORMAT_TOML); err == nil {
+		t.Errorf("Expected an error converting invalid TOML\n")
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const PREFIX_PATTERN_HTTP = "http"
+const PREFIX_PATTERN_HTTPS = "https"
+
+// Defaults for the "http" settings of a search pattern
+const HTTP_DEFAULT_TIMEOUT = 10 * time.Second
+const HTTP_DEFAULT_RETRIES = 2
+
+// HTTP_MAX_RESPONSE_SIZE is the largest response body read from http and https search
+// patterns and from Secrets Manager, in bytes. Larger responses fail without retries.
+const HTTP_MAX_RESPONSE_SIZE = 10 << 20
+
+// httpRetryDelay is the delay before the first retry, doubling for each following one
+var httpRetryDelay = 500 * time.Millisecond
+
+var httpClient = &http.Client{}
+
+// httpResponse is a cached response body. Responses without a cacheTTL have a zero
+// expiresAt and are kept until the next Initialize.
+type httpResponse struct {
+	body      string
+	expiresAt time.Time
+}
+
+func (r httpResponse) valid(now time.Time) bool {
+	return r.expiresAt.IsZero() || now.Before(r.expiresAt)
+}
+
+var httpMutex sync.Mutex
+var httpResponses = make(map[string]httpResponse)
+var httpGroup singleflight.Group
+var httpNow = time.Now
+
+// httpSettings are the "http" settings of a search pattern, with the headers rendered
+type httpSettings struct {
+	headers  map[string]string
+	timeout  time.Duration
+	retries  int
+	cacheTTL time.Duration
+}
+
+// httpRetryable is an error worth retrying, a network error or a 5xx or 429 status
+type httpRetryable struct {
+	err error
+}
+
+func (e httpRetryable) Error() string {
+	return e.err.Error()
+}
+
+// processHTTPSearchPattern handles http://host/path[:$.JSONPath] and https://host/path[:$.JSONPath].
+// Without a selector the response body is the value, with one it must be JSON.
+func processHTTPSearchPattern(ctx context.Context, patternComponents []string, options gjson.Result) (string, bool) {
+	url, selector := splitHTTPPattern(patternComponents)
+	if !strings.HasPrefix(url, patternComponents[0]+"://") {
+		log.Errorln("Invalid URL in searchPattern", redactPattern(url))
+		return "", false
+	}
+	settings, err := loadHTTPSettings(ctx, options)
+	if err != nil {
+		log.Errorln("Failed to prepare request to", redactPattern(url), err)
+		return "", false
+	}
+	body, err := readHTTPResource(ctx, url, settings)
+	if err != nil {
+		log.Errorln("Failed to read", redactPattern(url), err)
+		return "", false
+	}
+	if selector != "" {
+		return processSelector(body, selector)
+	}
+	return body, true
+}
+
+// splitHTTPPattern joins the components of the URL again, as the port and the path may
+// contain colons, and returns the URL and the selector
+func splitHTTPPattern(patternComponents []string) (string, string) {
+	last := len(patternComponents) - 1
+	if last > 1 && (strings.HasPrefix(patternComponents[last], "$") || strings.HasPrefix(patternComponents[last], SELECTOR_RECURSIVE_PREFIX)) {
+		return strings.Join(patternComponents[:last], ":"), patternComponents[last]
+	}
+	return strings.Join(patternComponents, ":"), ""
+}
+
+var errResponseTooLarge = fmt.Errorf("response is larger than %d bytes", HTTP_MAX_RESPONSE_SIZE)
+
+// readResponseBody reads a response body of at most HTTP_MAX_RESPONSE_SIZE bytes
+func readResponseBody(body io.Reader) ([]byte, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(body, HTTP_MAX_RESPONSE_SIZE+1))
+	if err == nil && len(content) > HTTP_MAX_RESPONSE_SIZE {
+		return nil, errResponseTooLarge
+	}
+	return content, err
+}
+
+// resetHTTP drops the responses cached without a cacheTTL, so that a new load requests them again
+func resetHTTP() {
+	httpMutex.Lock()
+	defer httpMutex.Unlock()
+	for key, response := range httpResponses {
+		if response.expiresAt.IsZero() {
+			delete(httpResponses, key)
+		}
+	}
+}
+
+// loadHTTPSettings reads the "http" settings, rendering the ${mapping} and ${env:NAME}
+// references of header values and adding the bearer token read from bearerTokenMapping
+func loadHTTPSettings(ctx context.Context, options gjson.Result) (httpSettings, error) {
+	settings := httpSettings{headers: make(map[string]string), timeout: HTTP_DEFAULT_TIMEOUT, retries: HTTP_DEFAULT_RETRIES}
+	var err error
+	options.Get("headers").ForEach(func(name, value gjson.Result) bool {
+		var header string
+		if header, err = renderTemplate(ctx, value.String(), ""); err != nil {
+			err = fmt.Errorf("header %s: %v", name.String(), err)
+			return false
+		}
+		settings.headers[name.String()] = header
+		return true
+	})
+	if err != nil {
+		return settings, err
+	}
+	if mapping := options.Get("bearerTokenMapping").String(); mapping != "" {
+		token, ok := GetStringContext(ctx, mapping)
+		if !ok {
+			return settings, fmt.Errorf("bearer token mapping %s is not resolved", mapping)
+		}
+		settings.headers["Authorization"] = "Bearer " + token
+	}
+	if timeout := options.Get("timeout").String(); timeout != "" {
+		if settings.timeout, err = time.ParseDuration(timeout); err != nil || settings.timeout <= 0 {
+			return settings, fmt.Errorf("invalid timeout %q", timeout)
+		}
+	}
+	if retries := options.Get("retries"); retries.Exists() {
+		if settings.retries = int(retries.Int()); settings.retries < 0 {
+			return settings, fmt.Errorf("invalid retries %s", retries.Raw)
+		}
+	}
+	if cacheTTL := options.Get("cacheTTL").String(); cacheTTL != "" {
+		if settings.cacheTTL, err = time.ParseDuration(cacheTTL); err != nil || settings.cacheTTL <= 0 {
+			return settings, fmt.Errorf("invalid cacheTTL %q", cacheTTL)
+		}
+	}
+	return settings, nil
+}
+
+// cacheKey identifies a request by its URL and headers, so that requests sent with
+// different credentials do not share a response
+func (s httpSettings) cacheKey(url string) string {
+	names := make([]string, 0, len(s.headers))
+	for name := range s.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := url
+	for _, name := range names {
+		key += "\n" + http.CanonicalHeaderKey(name) + ": " + s.headers[name]
+	}
+	return key
+}
+
+// readHTTPResource returns the response body of a GET request, from the cache while it is valid
+func readHTTPResource(ctx context.Context, url string, settings httpSettings) (string, error) {
+	key := settings.cacheKey(url)
+	httpMutex.Lock()
+	response, ok := httpResponses[key]
+	httpMutex.Unlock()
+	if ok && response.valid(httpNow()) {
+		return response.body, nil
+	}
+
+	body, err, _ := httpGroup.Do(key, func() (interface{}, error) {
+		body, err := requestWithRetries(ctx, url, settings)
+		if err != nil {
+			return "", err
+		}
+		response := httpResponse{body: body}
+		if settings.cacheTTL > 0 {
+			response.expiresAt = httpNow().Add(settings.cacheTTL)
+		}
+		httpMutex.Lock()
+		httpResponses[key] = response
+		httpMutex.Unlock()
+		return body, nil
+	})
+	return body.(string), err
+}
+
+// requestWithRetries retries failed requests with exponential backoff until the retries
+// are used up or ctx is done
+func requestWithRetries(ctx context.Context, url string, settings httpSettings) (string, error) {
+	delay := httpRetryDelay
+	for attempt := 0; ; attempt++ {
+		body, err := requestHTTP(ctx, url, settings)
+		if _, retryable := err.(httpRetryable); !retryable || attempt == settings.retries {
+			return body, err
+		}
+		log.Debugln("Retrying request to", redactPattern(url), "after", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// requestHTTP sends one GET request, limited by the timeout of the settings
+func requestHTTP(ctx context.Context, url string, settings httpSettings) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, settings.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range settings.headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil && ctx.Err() != context.DeadlineExceeded {
+			return "", ctx.Err()
+		}
+		return "", httpRetryable{err}
+	}
+	defer response.Body.Close()
+	content, err := readResponseBody(response.Body)
+	if err == errResponseTooLarge {
+		return "", err
+	} else if err != nil {
+		return "", httpRetryable{err}
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		err := fmt.Errorf("request failed with status %d: %s", response.StatusCode, http.StatusText(response.StatusCode))
+		if response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests {
+			return "", httpRetryable{err}
+		}
+		return "", err
+	}
+	return string(content), nil
+}
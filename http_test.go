@@ -0,0 +1,268 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// httpStandIn serves a config document for requests with the expected credentials, an
+// instance ID and an endpoint failing with 503 a given number of times
+type httpStandIn struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests map[string]int
+	failures int
+}
+
+func newHTTPStandIn(t *testing.T) *httpStandIn {
+	resetHTTP()
+	httpRetryDelay = time.Millisecond
+	t.Cleanup(func() { httpRetryDelay = 500 * time.Millisecond })
+	standIn := &httpStandIn{requests: make(map[string]int)}
+	standIn.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		standIn.mutex.Lock()
+		standIn.requests[r.URL.Path]++
+		fail := r.URL.Path == "/flaky" && standIn.requests[r.URL.Path] <= standIn.failures
+		standIn.mutex.Unlock()
+		switch {
+		case r.URL.Path == "/config/myapp":
+			if r.Header.Get("Authorization") != "Bearer config-token" || r.Header.Get("X-Tenant") != "team-a" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"database": {"host": "db.internal", "port": 5432}, "features": {"beta": true}}`))
+		case r.URL.Path == "/metadata/instance-id":
+			w.Write([]byte("i-0123456789"))
+		case r.URL.Path == "/large":
+			w.Write(make([]byte, HTTP_MAX_RESPONSE_SIZE+1))
+		case r.URL.Path == "/slow":
+			<-r.Context().Done()
+		case fail:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case r.URL.Path == "/flaky":
+			w.Write([]byte(`{"status": "ok"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(standIn.Close)
+	return standIn
+}
+
+func (s *httpStandIn) requestCount(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+func TestHTTPSearchPatterns(t *testing.T) {
+	server := newHTTPStandIn(t)
+	t.Setenv("HTTP_CONFIG_TENANT", "team-a")
+	t.Setenv("HTTP_CONFIG_AUTHORIZATION", "Bearer config-token")
+	credentials := gjson.Parse(`{"headers": {"Authorization": "${env:HTTP_CONFIG_AUTHORIZATION}", "X-Tenant": "${env:HTTP_CONFIG_TENANT}"}}`)
+
+	for _, test := range []struct {
+		pattern  string
+		expected string
+	}{
+		{server.URL + "/config/myapp:$.database.host", "db.internal"},
+		{server.URL + "/config/myapp:$.database.port", "5432"},
+		{server.URL + "/config/myapp:..beta", "true"},
+		{server.URL + "/metadata/instance-id", "i-0123456789"},
+	} {
+		if value, ok := processSearchPattern(context.Background(), "http_var", test.pattern, patternOptions{http: credentials}); !ok || value != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.pattern, value, test.expected)
+		}
+	}
+	for _, pattern := range []string{
+		server.URL + "/config/myapp:$.database.user",
+		server.URL + "/missing",
+		"http:config",
+	} {
+		if _, ok := processSearchPattern(context.Background(), "http_var", pattern, patternOptions{http: credentials}); ok {
+			t.Errorf("Expected %s not to resolve\n", pattern)
+		}
+	}
+	if _, ok := processSearchPattern(context.Background(), "http_var", server.URL+"/config/myapp:$.database.host", patternOptions{}); ok {
+		t.Errorf("Expected the request without credentials to be rejected\n")
+	}
+	unsetenv(t, "HTTP_CONFIG_TENANT")
+	if _, err := loadHTTPSettings(context.Background(), credentials); err == nil || !strings.Contains(err.Error(), "header X-Tenant") {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "an error for the unresolved header")
+	}
+}
+
+func TestHTTPSplitPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern  string
+		url      string
+		selector string
+	}{
+		{"https://config.internal:8443/v1/apps/myapp:$.database", "https://config.internal:8443/v1/apps/myapp", "$.database"},
+		{"https://config.internal:8443/v1/apps/myapp:$.items[?(@.name == 'a:b')].value", "https://config.internal:8443/v1/apps/myapp", "$.items[?(@.name == 'a:b')].value"},
+		{"http://169.254.169.254/metadata/v1/instance:..id", "http://169.254.169.254/metadata/v1/instance", "..id"},
+		{"http://169.254.169.254/metadata/v1/instance", "http://169.254.169.254/metadata/v1/instance", ""},
+	} {
+		url, selector := splitHTTPPattern(splitSearchPattern(test.pattern))
+		if url != test.url || selector != test.selector {
+			t.Errorf("Got: \t%s %s\n Wanted: \t%s %s\n", url, selector, test.url, test.selector)
+		}
+	}
+}
+
+func TestHTTPRetries(t *testing.T) {
+	server := newHTTPStandIn(t)
+	server.failures = 2
+	if value, ok := processSearchPattern(context.Background(), "http_var", server.URL+"/flaky:$.status", patternOptions{}); !ok || value != "ok" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "ok")
+	}
+	if server.requestCount("/flaky") != 3 {
+		t.Errorf("Expected two retries, got %d requests\n", server.requestCount("/flaky"))
+	}
+
+	server = newHTTPStandIn(t)
+	server.failures = 2
+	if _, ok := processSearchPattern(context.Background(), "http_var", server.URL+"/flaky", patternOptions{http: gjson.Parse(`{"retries": 1}`)}); ok {
+		t.Errorf("Expected the request to fail once the retries are used up\n")
+	}
+	if server.requestCount("/flaky") != 2 {
+		t.Errorf("Expected one retry, got %d requests\n", server.requestCount("/flaky"))
+	}
+	processSearchPattern(context.Background(), "http_var", server.URL+"/missing", patternOptions{})
+	if server.requestCount("/missing") != 1 {
+		t.Errorf("Expected client errors not to be retried, got %d requests\n", server.requestCount("/missing"))
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	server := newHTTPStandIn(t)
+	start := time.Now()
+	if _, ok := processSearchPattern(context.Background(), "http_var", server.URL+"/slow", patternOptions{http: gjson.Parse(`{"timeout": "50ms", "retries": 1}`)}); ok {
+		t.Errorf("Expected the slow request to time out\n")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the timeout to apply to each attempt, took %s\n", elapsed)
+	}
+	if server.requestCount("/slow") != 2 {
+		t.Errorf("Expected a timed out request to be retried, got %d requests\n", server.requestCount("/slow"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := readHTTPResource(ctx, server.URL+"/slow", httpSettings{timeout: time.Minute, retries: 5}); err == nil {
+		t.Errorf("Expected the deadline to abort the request\n")
+	}
+	if _, err := loadHTTPSettings(context.Background(), gjson.Parse(`{"timeout": "soon"}`)); err == nil || err.Error() != `invalid timeout "soon"` {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, `invalid timeout "soon"`)
+	}
+}
+
+func TestHTTPCache(t *testing.T) {
+	server := newHTTPStandIn(t)
+	now := time.Now()
+	httpNow = func() time.Time { return now }
+	defer func() { httpNow = time.Now }()
+	ttl := patternOptions{http: gjson.Parse(`{"cacheTTL": "1m"}`)}
+
+	for i := 0; i < 3; i++ {
+		processSearchPattern(context.Background(), "http_var", server.URL+"/metadata/instance-id", patternOptions{})
+		processSearchPattern(context.Background(), "http_var", server.URL+"/flaky", ttl)
+	}
+	if server.requestCount("/metadata/instance-id") != 1 || server.requestCount("/flaky") != 1 {
+		t.Errorf("Expected cached responses to be requested once, got %d and %d requests\n", server.requestCount("/metadata/instance-id"), server.requestCount("/flaky"))
+	}
+
+	// a new load requests responses without a cacheTTL again, the others once they expire
+	resetHTTP()
+	processSearchPattern(context.Background(), "http_var", server.URL+"/metadata/instance-id", patternOptions{})
+	processSearchPattern(context.Background(), "http_var", server.URL+"/flaky", ttl)
+	if server.requestCount("/metadata/instance-id") != 2 || server.requestCount("/flaky") != 1 {
+		t.Errorf("Expected only the response without a cacheTTL to be requested again, got %d and %d requests\n", server.requestCount("/metadata/instance-id"), server.requestCount("/flaky"))
+	}
+	now = now.Add(61 * time.Second)
+	processSearchPattern(context.Background(), "http_var", server.URL+"/flaky", ttl)
+	if server.requestCount("/flaky") != 2 {
+		t.Errorf("Expected the response to be requested again after its cacheTTL, got %d requests\n", server.requestCount("/flaky"))
+	}
+
+	// responses are cached per set of headers
+	processSearchPattern(context.Background(), "http_var", server.URL+"/metadata/instance-id", patternOptions{http: gjson.Parse(`{"headers": {"X-Tenant": "team-b"}}`)})
+	if server.requestCount("/metadata/instance-id") != 3 {
+		t.Errorf("Expected a request with other headers not to share the cached response, got %d requests\n", server.requestCount("/metadata/instance-id"))
+	}
+}
+
+func TestHTTPMappings(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	server := newHTTPStandIn(t)
+	t.Setenv("HTTP_CONFIG_TOKEN", "config-token")
+	t.Setenv("HTTP_CONFIG_TENANT", "team-a")
+	t.Setenv("HTTP_CONFIG_FALLBACK", "fallback")
+
+	mappings, _ := ioutil.ReadFile("server/config/http/mappings.json")
+	mappingsFile := t.TempDir() + "/mappings.json"
+	ioutil.WriteFile(mappingsFile, []byte(strings.Replace(string(mappings), "http://127.0.0.1:8089", server.URL, -1)), 0644)
+	Initialize(mappingsFile)
+	for name, expected := range map[string]string{
+		"http_var1":     "db.internal",
+		"http_var2":     "i-0123456789",
+		"http_var3":     "fallback",
+		"http_template": "db.internal/i-0123456789",
+	} {
+		if value, _ := GetString(name); value != expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", name, value, expected)
+		}
+	}
+}
+
+func TestHTTPStatusRedacted(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	server := newHTTPStandIn(t)
+	pattern := strings.Replace(server.URL, "http://", "http://admin:s3cret@", 1) + "/metadata/instance-id?token=abc"
+	mappingsFile := t.TempDir() + "/mappings.json"
+	ioutil.WriteFile(mappingsFile, []byte(`{"http_secret": {"searchPatterns": ["`+pattern+`"]}}`), 0644)
+	Initialize(mappingsFile)
+
+	expected := "http:" + redactPattern(pattern)
+	status, _ := mappingStatus("http_secret")
+	if !status.Resolved || status.Pattern != expected {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", status.Pattern, expected)
+	}
+	if report := getDebugReport(t, nil); strings.Contains(report.Raw, "s3cret") || strings.Contains(report.Raw, "token=") {
+		t.Errorf("Report contains the credentials of the URL: %s\n", report.Get(`mappings.#(name=="http_secret")`).Raw)
+	}
+}
+
+func TestHTTPResponseLimit(t *testing.T) {
+	server := newHTTPStandIn(t)
+	if _, err := readHTTPResource(context.Background(), server.URL+"/large", httpSettings{timeout: time.Minute, retries: 2}); err != errResponseTooLarge {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", err, errResponseTooLarge)
+	}
+	if server.requestCount("/large") != 1 {
+		t.Errorf("Expected a response too large not to be retried, got %d requests\n", server.requestCount("/large"))
+	}
+}
@@ -0,0 +1,249 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const IAM_DEFAULT_TOKEN_URL = "https://iam.cloud.ibm.com/identity/token"
+const IAM_GRANT_TYPE_APIKEY = "urn:ibm:params:oauth:grant-type:apikey"
+
+// IAM tokens are refreshed in the background once this fraction of their lifetime has passed
+const iamRefreshFraction = 0.8
+
+// iamRefreshRetryDelay is how long a failed background refresh waits before the next one
+var iamRefreshRetryDelay = 30 * time.Second
+
+// IAMOptions configures an IAMTokenProvider; the zero value talks to the public IAM endpoint
+type IAMOptions struct {
+	TokenURL   string
+	HTTPClient *http.Client
+}
+
+// IAMTokenProvider exchanges the apikey held by a mapping for IAM access tokens.
+// Tokens are cached and refreshed in the background before they expire.
+type IAMTokenProvider struct {
+	mappingName  string
+	tokenURL     string
+	client       *http.Client
+	lookupApikey func(ctx context.Context) (string, bool)
+
+	fetches    singleflight.Group
+	mutex      sync.Mutex
+	token      string
+	expiration time.Time
+	refreshAt  time.Time
+	refreshing bool
+	now        func() time.Time
+}
+
+type iamTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Expiration  int64  `json:"expiration"`
+}
+
+// NewIAMTokenProvider creates a token provider for the apikey mapping. The mapping may hold the
+// apikey itself or credentials containing an "apikey" or "iam_apikey" key. The apikey is looked
+// up on every exchange, so a new key is picked up after Initialize runs again.
+func NewIAMTokenProvider(mappingName string, opts *IAMOptions) *IAMTokenProvider {
+	options := IAMOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.TokenURL == "" {
+		options.TokenURL = IAM_DEFAULT_TOKEN_URL
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &IAMTokenProvider{
+		mappingName: mappingName,
+		tokenURL:    options.TokenURL,
+		client:      options.HTTPClient,
+		lookupApikey: func(ctx context.Context) (string, bool) {
+			return GetStringContext(ctx, mappingName)
+		},
+		now: time.Now,
+	}
+}
+
+// Token returns a valid access token, exchanging the apikey when no token is cached or the
+// cached one expired. When the token is close to expiry it is still returned while a new one
+// is requested in the background.
+func (p *IAMTokenProvider) Token() (string, error) {
+	return p.TokenContext(context.Background())
+}
+
+// TokenContext is Token with a context for resolving the apikey mapping and for waiting on
+// the token exchange. Callers share one exchange, which ctx does not cancel, so that a
+// caller giving up does not fail the others; the exchange is bounded by the HTTP client.
+// Background refreshes are not bound to ctx.
+func (p *IAMTokenProvider) TokenContext(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	p.mutex.Lock()
+	now := p.now()
+	if p.token != "" && now.Before(p.expiration) {
+		token := p.token
+		if !now.Before(p.refreshAt) && !p.refreshing {
+			p.refreshing = true
+			go p.refresh()
+		}
+		p.mutex.Unlock()
+		return token, nil
+	}
+	p.mutex.Unlock()
+
+	exchange := p.fetches.DoChan("token", func() (interface{}, error) {
+		p.mutex.Lock()
+		valid := p.token != "" && p.now().Before(p.expiration)
+		p.mutex.Unlock()
+		if valid {
+			return nil, nil
+		}
+		return nil, p.fetch(context.WithoutCancel(ctx))
+	})
+	select {
+	case result := <-exchange:
+		if result.Err != nil {
+			return "", result.Err
+		}
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.token, nil
+}
+
+// refresh exchanges the apikey again while the current token is still valid. After a
+// failure the next refresh waits for iamRefreshRetryDelay, so that every call does not
+// start another exchange.
+func (p *IAMTokenProvider) refresh() {
+	_, err, _ := p.fetches.Do("token", func() (interface{}, error) {
+		return nil, p.fetch(context.Background())
+	})
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		log.Warnln("Failed to refresh IAM token for mapping", p.mappingName, err)
+		p.refreshAt = p.now().Add(iamRefreshRetryDelay)
+	}
+	p.refreshing = false
+}
+
+func (p *IAMTokenProvider) fetch(ctx context.Context) error {
+	apikey, err := p.apikey(ctx)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("grant_type", IAM_GRANT_TYPE_APIKEY)
+	form.Set("apikey", apikey)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("IAM token request failed: %v", err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("IAM token request failed: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("IAM token request failed with status %d: %s", response.StatusCode, gjson.GetBytes(body, "errorMessage").String())
+	}
+
+	var token iamTokenResponse
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return fmt.Errorf("IAM token response does not contain an access token")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	issued := p.now()
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	p.expiration = issued.Add(lifetime)
+	if token.Expiration > 0 {
+		p.expiration = time.Unix(token.Expiration, 0)
+		lifetime = p.expiration.Sub(issued)
+	}
+	p.refreshAt = issued.Add(time.Duration(float64(lifetime) * iamRefreshFraction))
+	p.token = token.AccessToken
+	return nil
+}
+
+func (p *IAMTokenProvider) apikey(ctx context.Context) (string, error) {
+	value, ok := p.lookupApikey(ctx)
+	if !ok || value == "" {
+		return "", fmt.Errorf("apikey mapping %s does not exist", p.mappingName)
+	}
+	if gjson.Valid(value) && gjson.Parse(value).IsObject() {
+		credentials := gjson.Parse(value)
+		for _, key := range []string{"apikey", "iam_apikey"} {
+			if apikey := credentials.Get(key).String(); apikey != "" {
+				return apikey, nil
+			}
+		}
+		return "", fmt.Errorf("mapping %s contains no apikey or iam_apikey", p.mappingName)
+	}
+	return value, nil
+}
+
+// RoundTripper returns an http.RoundTripper adding the IAM access token as bearer
+// authorization to every request. http.DefaultTransport is used when base is nil.
+func (p *IAMTokenProvider) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &iamRoundTripper{provider: p, base: base}
+}
+
+type iamRoundTripper struct {
+	provider *IAMTokenProvider
+	base     http.RoundTripper
+}
+
+func (t *iamRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	token, err := t.provider.TokenContext(request.Context())
+	if err != nil {
+		return nil, err
+	}
+	authorized := request.Clone(request.Context())
+	authorized.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(authorized)
+}
@@ -0,0 +1,219 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newIAMServer(t *testing.T, apikey string, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Form.Get("grant_type") != IAM_GRANT_TYPE_APIKEY || r.Form.Get("apikey") != apikey {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errorMessage": "Provided API key could not be found"}`)
+			return
+		}
+		count := atomic.AddInt32(requests, 1)
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 3600}`, count)
+	}))
+}
+
+func TestIAMTokenCaching(t *testing.T) {
+	var requests int32
+	server := newIAMServer(t, "test-apikey", &requests)
+	defer server.Close()
+
+	loadedMappings["iam_var1"] = `{"iam_apikey": "test-apikey"}`
+	provider := NewIAMTokenProvider("iam_var1", &IAMOptions{TokenURL: server.URL})
+	for i := 0; i < 3; i++ {
+		token, err := provider.Token()
+		if err != nil || token != "token-1" {
+			t.Errorf("Got: \t%s %v\n Wanted: \t%s\n", token, err, "token-1")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected a single token exchange, got %d\n", requests)
+	}
+}
+
+// waitForIAMRefresh waits until the background refresh of the provider finished
+func waitForIAMRefresh(provider *IAMTokenProvider) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		provider.mutex.Lock()
+		refreshing := provider.refreshing
+		provider.mutex.Unlock()
+		if !refreshing {
+			return
+		}
+	}
+}
+
+func TestIAMTokenProactiveRefresh(t *testing.T) {
+	var requests int32
+	server := newIAMServer(t, "test-apikey", &requests)
+	defer server.Close()
+
+	loadedMappings["iam_var2"] = "test-apikey"
+	provider := NewIAMTokenProvider("iam_var2", &IAMOptions{TokenURL: server.URL})
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+	if token, _ := provider.Token(); token != "token-1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", token, "token-1")
+	}
+
+	// past the refresh point the cached token is still served while a new one is fetched
+	now = now.Add(50 * time.Minute)
+	if token, _ := provider.Token(); token != "token-1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", token, "token-1")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&requests) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForIAMRefresh(provider)
+	if token, _ := provider.Token(); token != "token-2" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", token, "token-2")
+	}
+
+	// an expired token is exchanged synchronously
+	now = now.Add(2 * time.Hour)
+	if token, _ := provider.Token(); token != "token-3" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", token, "token-3")
+	}
+}
+
+func TestIAMRoundTripper(t *testing.T) {
+	var requests int32
+	iam := newIAMServer(t, "test-apikey", &requests)
+	defer iam.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer api.Close()
+
+	loadedMappings["iam_var3"] = `{"apikey": "test-apikey"}`
+	provider := NewIAMTokenProvider("iam_var3", &IAMOptions{TokenURL: iam.URL})
+	client := &http.Client{Transport: provider.RoundTripper(nil)}
+	response, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body := make([]byte, 64)
+	n, _ := response.Body.Read(body)
+	if string(body[:n]) != "Bearer token-1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", string(body[:n]), "Bearer token-1")
+	}
+}
+
+func TestIAMTokenErrors(t *testing.T) {
+	var requests int32
+	server := newIAMServer(t, "test-apikey", &requests)
+	defer server.Close()
+
+	loadedMappings["iam_var4"] = "wrong-apikey"
+	provider := NewIAMTokenProvider("iam_var4", &IAMOptions{TokenURL: server.URL})
+	if _, err := provider.Token(); err == nil {
+		t.Errorf("Expected error for rejected apikey\n")
+	}
+
+	provider = NewIAMTokenProvider("iam_missing", &IAMOptions{TokenURL: server.URL})
+	if _, err := provider.Token(); err == nil {
+		t.Errorf("Expected error for missing apikey mapping\n")
+	}
+}
+
+func TestIAMTokenContext(t *testing.T) {
+	var requests int32
+	server := newIAMServer(t, "test-apikey", &requests)
+	defer server.Close()
+
+	loadedMappings["iam_var5"] = "test-apikey"
+	provider := NewIAMTokenProvider("iam_var5", &IAMOptions{TokenURL: server.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := provider.TokenContext(ctx); err == nil || requests != 0 {
+		t.Errorf("Expected the canceled context to stop the token exchange, got %v after %d requests\n", err, requests)
+	}
+	if token, err := provider.TokenContext(context.Background()); err != nil || token != "token-1" {
+		t.Errorf("Got: \t%s %v\n Wanted: \t%s\n", token, err, "token-1")
+	}
+}
+
+func TestIAMTokenRefreshBackoff(t *testing.T) {
+	var requests int32
+	server := newIAMServer(t, "test-apikey", &requests)
+	defer server.Close()
+
+	loadedMappings["iam_var6"] = "test-apikey"
+	provider := NewIAMTokenProvider("iam_var6", &IAMOptions{TokenURL: server.URL})
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+	provider.Token()
+
+	// failed refreshes are not started again by every call
+	var attempts int32
+	provider.lookupApikey = func(context.Context) (string, bool) {
+		atomic.AddInt32(&attempts, 1)
+		return "", false
+	}
+	now = now.Add(50 * time.Minute)
+	for i := 0; i < 5; i++ {
+		if token, _ := provider.Token(); token != "token-1" {
+			t.Errorf("Got: \t%s\n Wanted: \t%s\n", token, "token-1")
+		}
+		waitForIAMRefresh(provider)
+	}
+	if atomic.LoadInt32(&attempts) != 1 {
+		t.Errorf("Expected a single refresh attempt, got %d\n", attempts)
+	}
+	now = now.Add(iamRefreshRetryDelay)
+	provider.Token()
+	waitForIAMRefresh(provider)
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("Expected another refresh attempt after the retry delay, got %d\n", attempts)
+	}
+}
+
+func TestIAMTokenWaitCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, `{"access_token": "token-1", "expires_in": 3600}`)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	loadedMappings["iam_var7"] = "test-apikey"
+	provider := NewIAMTokenProvider("iam_var7", &IAMOptions{TokenURL: server.URL})
+	go provider.Token()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := provider.TokenContext(ctx); err != context.DeadlineExceeded || time.Since(start) > 2*time.Second {
+		t.Errorf("Expected the wait for the token exchange to end with the context, got %v after %s\n", err, time.Since(start))
+	}
+}
@@ -0,0 +1,1302 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSONPath modes, chosen with the "jsonpath" key of a mappings file. The compatibility
+// mode accepts RFC 9535 expressions as well as forms used by existing mappings: member
+// names containing "-" or starting with a digit, filters without "?" such as
+// [@.name=='x'] and the =~ /regex/ operator.
+const JSONPATH_MODE_RFC9535 = "rfc9535"
+const JSONPATH_MODE_COMPAT = "compat"
+
+// the largest and smallest integers JSON can represent exactly, as required for indices
+const jsonPathMaxInt = 1<<53 - 1
+
+// JSONPath is a compiled RFC 9535 JSONPath expression
+type JSONPath struct {
+	expression string
+	segments   []jsonPathSegment
+}
+
+// JSONPathError reports an invalid JSONPath expression and the offset of the problem
+type JSONPathError struct {
+	Expression string
+	Offset     int
+	Message    string
+}
+
+func (e *JSONPathError) Error() string {
+	return fmt.Sprintf("invalid JSONPath %q: %s at offset %d", e.Expression, e.Message, e.Offset)
+}
+
+// CompileJSONPath compiles an RFC 9535 JSONPath expression
+func CompileJSONPath(expression string) (*JSONPath, error) {
+	return compileJSONPath(expression, false)
+}
+
+func compileJSONPath(expression string, compat bool) (*JSONPath, error) {
+	parser := &jsonPathParser{src: expression, compat: compat}
+	segments, err := parser.query()
+	if err != nil {
+		return nil, err
+	}
+	return &JSONPath{expression: expression, segments: segments}, nil
+}
+
+func (p *JSONPath) String() string {
+	return p.expression
+}
+
+// Query returns the nodes the expression selects from a document decoded with
+// encoding/json. Object members are visited in key order.
+func (p *JSONPath) Query(document interface{}) []interface{} {
+	nodes := applySegments(p.segments, document, []interface{}{document})
+	if nodes == nil {
+		return []interface{}{}
+	}
+	return nodes
+}
+
+// Singular reports whether the expression selects at most one node
+func (p *JSONPath) Singular() bool {
+	return singularSegments(p.segments)
+}
+
+var jsonPathModeMutex sync.RWMutex
+var jsonPathMode = JSONPATH_MODE_COMPAT
+
+type jsonPathCacheKey struct {
+	expression string
+	compat     bool
+}
+
+type jsonPathCacheEntry struct {
+	path *JSONPath
+	err  error
+}
+
+var jsonPathCacheMutex sync.RWMutex
+var jsonPathCache = make(map[jsonPathCacheKey]jsonPathCacheEntry)
+
+func setJSONPathMode(mode string) {
+	jsonPathModeMutex.Lock()
+	defer jsonPathModeMutex.Unlock()
+	jsonPathMode = mode
+}
+
+// cachedJSONPath compiles an expression in the current mode once
+func cachedJSONPath(expression string) (*JSONPath, error) {
+	jsonPathModeMutex.RLock()
+	key := jsonPathCacheKey{expression: expression, compat: jsonPathMode != JSONPATH_MODE_RFC9535}
+	jsonPathModeMutex.RUnlock()
+
+	jsonPathCacheMutex.RLock()
+	entry, ok := jsonPathCache[key]
+	jsonPathCacheMutex.RUnlock()
+	if ok {
+		return entry.path, entry.err
+	}
+	path, err := compileJSONPath(expression, key.compat)
+	jsonPathCacheMutex.Lock()
+	jsonPathCache[key] = jsonPathCacheEntry{path: path, err: err}
+	jsonPathCacheMutex.Unlock()
+	return path, err
+}
+
+// jsonPathResult formats the nodes selected by a query the way search patterns return
+// them: the value of a singular query, or a JSON array of the nodes of any other query.
+// Queries that select nothing or a JSON null are not found.
+func jsonPathResult(path *JSONPath, document interface{}) (string, bool) {
+	nodes := path.Query(document)
+	if len(nodes) == 0 {
+		return "", false
+	}
+	if !path.Singular() {
+		result, err := json.Marshal(nodes)
+		return string(result), err == nil
+	}
+	return formatJSONValue(nodes[0])
+}
+
+// formatJSONValue returns strings, numbers and booleans as text and objects and arrays as
+// compact JSON; null is not a value
+func formatJSONValue(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	default:
+		result, err := json.Marshal(value)
+		return string(result), err == nil
+	}
+}
+
+// decodeJSONDocument decodes a document keeping numbers as written
+func decodeJSONDocument(jsonString string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(jsonString))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after JSON document")
+	}
+	return document, nil
+}
+
+// Segments and selectors
+
+type jsonPathSegment struct {
+	descendant bool
+	selectors  []jsonPathSelector
+}
+
+type jsonPathSelector interface {
+	selectNodes(root, node interface{}, nodes []interface{}) []interface{}
+}
+
+type nameSelector struct{ name string }
+type wildcardSelector struct{}
+type indexSelector struct{ index int }
+type sliceSelector struct{ start, end, step *int }
+type filterSelector struct{ expression logicalExpression }
+
+func (s nameSelector) selectNodes(_, node interface{}, nodes []interface{}) []interface{} {
+	if object, ok := node.(map[string]interface{}); ok {
+		if value, found := object[s.name]; found {
+			nodes = append(nodes, value)
+		}
+	}
+	return nodes
+}
+
+func (wildcardSelector) selectNodes(_, node interface{}, nodes []interface{}) []interface{} {
+	return append(nodes, children(node)...)
+}
+
+func (s indexSelector) selectNodes(_, node interface{}, nodes []interface{}) []interface{} {
+	if array, ok := node.([]interface{}); ok {
+		index := s.index
+		if index < 0 {
+			index += len(array)
+		}
+		if index >= 0 && index < len(array) {
+			nodes = append(nodes, array[index])
+		}
+	}
+	return nodes
+}
+
+func (s sliceSelector) selectNodes(_, node interface{}, nodes []interface{}) []interface{} {
+	array, ok := node.([]interface{})
+	if !ok {
+		return nodes
+	}
+	step := 1
+	if s.step != nil {
+		step = *s.step
+	}
+	if step == 0 {
+		return nodes
+	}
+	length := len(array)
+	normalize := func(index int) int {
+		if index < 0 {
+			return length + index
+		}
+		return index
+	}
+	bound := func(index, lower, upper int) int {
+		return minInt(maxInt(index, lower), upper)
+	}
+	if step > 0 {
+		start, end := 0, length
+		if s.start != nil {
+			start = normalize(*s.start)
+		}
+		if s.end != nil {
+			end = normalize(*s.end)
+		}
+		for i := bound(start, 0, length); i < bound(end, 0, length); i += step {
+			nodes = append(nodes, array[i])
+		}
+		return nodes
+	}
+	start, end := length-1, -length-1
+	if s.start != nil {
+		start = normalize(*s.start)
+	}
+	if s.end != nil {
+		end = normalize(*s.end)
+	}
+	for i := bound(start, -1, length-1); bound(end, -1, length-1) < i; i += step {
+		nodes = append(nodes, array[i])
+	}
+	return nodes
+}
+
+func (s filterSelector) selectNodes(root, node interface{}, nodes []interface{}) []interface{} {
+	for _, child := range children(node) {
+		if s.expression.test(root, child) {
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// children returns the elements of an array or the member values of an object in key order
+func children(node interface{}) []interface{} {
+	switch value := node.(type) {
+	case []interface{}:
+		return value
+	case map[string]interface{}:
+		result := make([]interface{}, 0, len(value))
+		for _, key := range sortedKeys(value) {
+			result = append(result, value[key])
+		}
+		return result
+	}
+	return nil
+}
+
+func descendants(node interface{}, nodes []interface{}) []interface{} {
+	nodes = append(nodes, node)
+	for _, child := range children(node) {
+		nodes = descendants(child, nodes)
+	}
+	return nodes
+}
+
+func applySegments(segments []jsonPathSegment, root interface{}, nodes []interface{}) []interface{} {
+	for _, segment := range segments {
+		var next []interface{}
+		for _, node := range nodes {
+			visit := []interface{}{node}
+			if segment.descendant {
+				visit = descendants(node, nil)
+			}
+			for _, current := range visit {
+				for _, selector := range segment.selectors {
+					next = selector.selectNodes(root, current, next)
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func singularSegments(segments []jsonPathSegment) bool {
+	for _, segment := range segments {
+		if segment.descendant || len(segment.selectors) != 1 {
+			return false
+		}
+		switch segment.selectors[0].(type) {
+		case nameSelector, indexSelector:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Filter expressions
+
+type logicalExpression interface {
+	test(root, current interface{}) bool
+}
+
+type orExpression []logicalExpression
+type andExpression []logicalExpression
+type notExpression struct{ expression logicalExpression }
+type existsExpression struct{ query *filterQuery }
+type functionTest struct{ function *functionExpression }
+type comparisonExpression struct {
+	left, right comparableExpression
+	operator    string
+}
+
+func (e orExpression) test(root, current interface{}) bool {
+	for _, expression := range e {
+		if expression.test(root, current) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e andExpression) test(root, current interface{}) bool {
+	for _, expression := range e {
+		if !expression.test(root, current) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e notExpression) test(root, current interface{}) bool {
+	return !e.expression.test(root, current)
+}
+
+func (e existsExpression) test(root, current interface{}) bool {
+	return len(e.query.nodes(root, current)) > 0
+}
+
+func (e functionTest) test(root, current interface{}) bool {
+	if e.function.definition.result == nodesType {
+		return len(e.function.nodes(root, current)) > 0
+	}
+	value, _ := e.function.value(root, current)
+	return value == true
+}
+
+func (e comparisonExpression) test(root, current interface{}) bool {
+	left, leftFound := e.left.value(root, current)
+	right, rightFound := e.right.value(root, current)
+	switch e.operator {
+	case "==":
+		return jsonValuesEqual(left, leftFound, right, rightFound)
+	case "!=":
+		return !jsonValuesEqual(left, leftFound, right, rightFound)
+	case "<":
+		return jsonValueLess(left, leftFound, right, rightFound)
+	case "<=":
+		return jsonValueLess(left, leftFound, right, rightFound) || jsonValuesEqual(left, leftFound, right, rightFound)
+	case ">":
+		return jsonValueLess(right, rightFound, left, leftFound)
+	case ">=":
+		return jsonValueLess(right, rightFound, left, leftFound) || jsonValuesEqual(left, leftFound, right, rightFound)
+	}
+	return false
+}
+
+// comparableExpression produces a value, or reports that there is nothing to compare
+type comparableExpression interface {
+	value(root, current interface{}) (interface{}, bool)
+}
+
+type literal struct{ literal interface{} }
+
+func (l literal) value(_, _ interface{}) (interface{}, bool) {
+	return l.literal, true
+}
+
+type filterQuery struct {
+	relative bool
+	segments []jsonPathSegment
+}
+
+func (q *filterQuery) nodes(root, current interface{}) []interface{} {
+	start := root
+	if q.relative {
+		start = current
+	}
+	return applySegments(q.segments, root, []interface{}{start})
+}
+
+func (q *filterQuery) value(root, current interface{}) (interface{}, bool) {
+	nodes := q.nodes(root, current)
+	if len(nodes) != 1 {
+		return nil, false
+	}
+	return nodes[0], true
+}
+
+func (q *filterQuery) singular() bool {
+	return singularSegments(q.segments)
+}
+
+// Function extensions
+
+type functionType int
+
+const (
+	valueType functionType = iota
+	logicalType
+	nodesType
+)
+
+type functionDefinition struct {
+	parameters []functionType
+	result     functionType
+	evaluate   func(arguments []functionArgument) interface{}
+}
+
+// functionArgument holds a value, whether there was one, and the nodes of query arguments
+type functionArgument struct {
+	value interface{}
+	found bool
+	nodes []interface{}
+}
+
+var jsonPathFunctions = map[string]functionDefinition{
+	"length": {[]functionType{valueType}, valueType, func(arguments []functionArgument) interface{} {
+		switch value := arguments[0].value; value := value.(type) {
+		case string:
+			return float64(utf8.RuneCountInString(value))
+		case []interface{}:
+			return float64(len(value))
+		case map[string]interface{}:
+			return float64(len(value))
+		}
+		return nothing{}
+	}},
+	"count": {[]functionType{nodesType}, valueType, func(arguments []functionArgument) interface{} {
+		return float64(len(arguments[0].nodes))
+	}},
+	"match": {[]functionType{valueType, valueType}, logicalType, func(arguments []functionArgument) interface{} {
+		return regexpMatches(arguments[0].value, arguments[1].value, true)
+	}},
+	"search": {[]functionType{valueType, valueType}, logicalType, func(arguments []functionArgument) interface{} {
+		return regexpMatches(arguments[0].value, arguments[1].value, false)
+	}},
+	"value": {[]functionType{nodesType}, valueType, func(arguments []functionArgument) interface{} {
+		if len(arguments[0].nodes) == 1 {
+			return arguments[0].nodes[0]
+		}
+		return nothing{}
+	}},
+}
+
+// nothing is the result of a function that produced no value
+type nothing struct{}
+
+type functionExpression struct {
+	name       string
+	definition functionDefinition
+	arguments  []interface{}
+}
+
+func (f *functionExpression) evaluate(root, current interface{}) interface{} {
+	arguments := make([]functionArgument, len(f.arguments))
+	for i, argument := range f.arguments {
+		switch argument := argument.(type) {
+		case *filterQuery:
+			arguments[i].nodes = argument.nodes(root, current)
+			arguments[i].value, arguments[i].found = argument.value(root, current)
+		case comparableExpression:
+			arguments[i].value, arguments[i].found = argument.value(root, current)
+		case logicalExpression:
+			arguments[i].value, arguments[i].found = argument.test(root, current), true
+		}
+	}
+	return f.definition.evaluate(arguments)
+}
+
+func (f *functionExpression) value(root, current interface{}) (interface{}, bool) {
+	result := f.evaluate(root, current)
+	if _, empty := result.(nothing); empty {
+		return nil, false
+	}
+	return result, true
+}
+
+func (f *functionExpression) nodes(root, current interface{}) []interface{} {
+	nodes, _ := f.evaluate(root, current).([]interface{})
+	return nodes
+}
+
+var regexpCacheMutex sync.RWMutex
+var regexpCache = make(map[string]*regexp.Regexp)
+
+// regexpMatches implements match() and search() with I-Regexp (RFC 9485) patterns;
+// invalid patterns and non-string arguments do not match
+func regexpMatches(value, pattern interface{}, full bool) bool {
+	text, ok := value.(string)
+	expression, isString := pattern.(string)
+	if !ok || !isString {
+		return false
+	}
+	translated := translateIRegexp(expression)
+	if full {
+		translated = `\A(?:` + translated + `)\z`
+	}
+	regexpCacheMutex.RLock()
+	compiled, cached := regexpCache[translated]
+	regexpCacheMutex.RUnlock()
+	if !cached {
+		compiled, _ = regexp.Compile(translated)
+		regexpCacheMutex.Lock()
+		regexpCache[translated] = compiled
+		regexpCacheMutex.Unlock()
+	}
+	return compiled != nil && compiled.MatchString(text)
+}
+
+// translateIRegexp rewrites the I-Regexp "." which, unlike RE2, does not match \n or \r
+func translateIRegexp(expression string) string {
+	var result strings.Builder
+	inClass := false
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+		switch {
+		case c == '\\' && i+1 < len(expression):
+			result.WriteByte(c)
+			i++
+			result.WriteByte(expression[i])
+			continue
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '.' && !inClass:
+			result.WriteString(`[^\n\r]`)
+			continue
+		}
+		result.WriteByte(c)
+	}
+	return result.String()
+}
+
+// Comparison semantics of RFC 9535 section 2.3.5.2.2
+
+func jsonValuesEqual(left interface{}, leftFound bool, right interface{}, rightFound bool) bool {
+	if !leftFound || !rightFound {
+		return leftFound == rightFound
+	}
+	return jsonEqual(left, right)
+}
+
+func jsonEqual(left, right interface{}) bool {
+	if leftNumber, ok := jsonNumber(left); ok {
+		rightNumber, ok := jsonNumber(right)
+		return ok && leftNumber == rightNumber
+	}
+	switch leftValue := left.(type) {
+	case nil:
+		return right == nil
+	case string, bool:
+		return left == right
+	case []interface{}:
+		rightValue, ok := right.([]interface{})
+		if !ok || len(leftValue) != len(rightValue) {
+			return false
+		}
+		for i := range leftValue {
+			if !jsonEqual(leftValue[i], rightValue[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		rightValue, ok := right.(map[string]interface{})
+		if !ok || len(leftValue) != len(rightValue) {
+			return false
+		}
+		for key, value := range leftValue {
+			other, found := rightValue[key]
+			if !found || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func jsonValueLess(left interface{}, leftFound bool, right interface{}, rightFound bool) bool {
+	if !leftFound || !rightFound {
+		return false
+	}
+	if leftNumber, ok := jsonNumber(left); ok {
+		rightNumber, ok := jsonNumber(right)
+		return ok && leftNumber < rightNumber
+	}
+	leftString, ok := left.(string)
+	rightString, isString := right.(string)
+	return ok && isString && leftString < rightString
+}
+
+func jsonNumber(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int:
+		return float64(number), true
+	case json.Number:
+		parsed, err := number.Float64()
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+// Parser for the RFC 9535 grammar
+
+type jsonPathParser struct {
+	src    string
+	pos    int
+	compat bool
+}
+
+func (p *jsonPathParser) errorf(format string, arguments ...interface{}) error {
+	return &JSONPathError{Expression: p.src, Offset: p.pos, Message: fmt.Sprintf(format, arguments...)}
+}
+
+func (p *jsonPathParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *jsonPathParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *jsonPathParser) consume(token string) bool {
+	if strings.HasPrefix(p.src[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *jsonPathParser) skipBlank() {
+	for !p.eof() && strings.IndexByte(" \t\n\r", p.peek()) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jsonPathParser) unexpected() error {
+	if p.eof() {
+		return p.errorf("unexpected end of expression")
+	}
+	return p.errorf("unexpected character %q", p.peek())
+}
+
+func (p *jsonPathParser) query() ([]jsonPathSegment, error) {
+	if !p.consume("$") {
+		return nil, p.errorf("expression must start with $")
+	}
+	segments, err := p.segments()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, p.unexpected()
+	}
+	return segments, nil
+}
+
+func (p *jsonPathParser) segments() ([]jsonPathSegment, error) {
+	var segments []jsonPathSegment
+	for {
+		start := p.pos
+		p.skipBlank()
+		if p.peek() != '.' && p.peek() != '[' {
+			p.pos = start
+			return segments, nil
+		}
+		segment, err := p.segment()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+}
+
+func (p *jsonPathParser) segment() (jsonPathSegment, error) {
+	var segment jsonPathSegment
+	var err error
+	switch {
+	case p.consume(".."):
+		segment.descendant = true
+		if p.peek() == '[' {
+			segment.selectors, err = p.bracketedSelection()
+			return segment, err
+		}
+		fallthrough
+	case p.consume("."):
+		if p.consume("*") {
+			segment.selectors = []jsonPathSelector{wildcardSelector{}}
+			return segment, nil
+		}
+		name, err := p.memberName()
+		if err != nil {
+			return segment, err
+		}
+		segment.selectors = []jsonPathSelector{nameSelector{name}}
+	default:
+		segment.selectors, err = p.bracketedSelection()
+	}
+	return segment, err
+}
+
+func (p *jsonPathParser) memberName() (string, error) {
+	start := p.pos
+	for !p.eof() {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		first := p.pos == start
+		if r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || r >= 0x80 ||
+			(r >= '0' && r <= '9' && (!first || p.compat)) || (r == '-' && p.compat) {
+			p.pos += size
+			continue
+		}
+		break
+	}
+	if p.pos == start {
+		return "", p.unexpected()
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *jsonPathParser) bracketedSelection() ([]jsonPathSelector, error) {
+	p.pos++
+	var selectors []jsonPathSelector
+	for {
+		p.skipBlank()
+		selector, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+		p.skipBlank()
+		if p.consume("]") {
+			return selectors, nil
+		}
+		if !p.consume(",") {
+			return nil, p.unexpected()
+		}
+	}
+}
+
+func (p *jsonPathParser) selector() (jsonPathSelector, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		name, err := p.stringLiteral()
+		return nameSelector{name}, err
+	case c == '*':
+		p.pos++
+		return wildcardSelector{}, nil
+	case c == '?':
+		p.pos++
+		p.skipBlank()
+		expression, err := p.logicalOr()
+		return filterSelector{expression}, err
+	case c == '@' && p.compat:
+		expression, err := p.logicalOr()
+		return filterSelector{expression}, err
+	case c == '-' || c == ':' || (c >= '0' && c <= '9'):
+		return p.indexOrSlice()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *jsonPathParser) indexOrSlice() (jsonPathSelector, error) {
+	var bounds [3]*int
+	for i := 0; i < 3; i++ {
+		if c := p.peek(); c == '-' || (c >= '0' && c <= '9') {
+			value, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			bounds[i] = &value
+			p.skipBlank()
+		}
+		if i == 0 && p.peek() != ':' {
+			if bounds[0] == nil {
+				return nil, p.unexpected()
+			}
+			return indexSelector{*bounds[0]}, nil
+		}
+		if i == 2 || !p.consume(":") {
+			break
+		}
+		p.skipBlank()
+	}
+	return sliceSelector{start: bounds[0], end: bounds[1], step: bounds[2]}, nil
+}
+
+func (p *jsonPathParser) integer() (int, error) {
+	start := p.pos
+	p.consume("-")
+	digits := p.pos
+	for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	if p.pos == digits || (p.src[digits] == '0' && (p.pos-digits > 1 || digits > start)) {
+		p.pos = start
+		return 0, p.errorf("invalid integer %q", text)
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || value > jsonPathMaxInt || value < -jsonPathMaxInt {
+		p.pos = start
+		return 0, p.errorf("integer %s out of range", text)
+	}
+	return int(value), nil
+}
+
+func (p *jsonPathParser) stringLiteral() (string, error) {
+	quote := p.peek()
+	p.pos++
+	var result strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		switch {
+		case c == quote:
+			p.pos++
+			return result.String(), nil
+		case c < 0x20:
+			return "", p.errorf("control character in string")
+		case c == '\\':
+			p.pos++
+			r, err := p.escape(quote)
+			if err != nil {
+				return "", err
+			}
+			result.WriteRune(r)
+		default:
+			result.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *jsonPathParser) escape(quote byte) (rune, error) {
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case '/', '\\':
+		return rune(c), nil
+	case 'u':
+		r, err := p.hex4()
+		if err != nil {
+			return 0, err
+		}
+		if utf16.IsSurrogate(r) {
+			if r >= 0xDC00 || !p.consume(`\u`) {
+				return 0, p.errorf("invalid surrogate pair")
+			}
+			low, err := p.hex4()
+			if err != nil {
+				return 0, err
+			}
+			if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+				return 0, p.errorf("invalid surrogate pair")
+			}
+		}
+		return r, nil
+	}
+	if c == quote {
+		return rune(c), nil
+	}
+	p.pos--
+	return 0, p.errorf("invalid escape")
+}
+
+func (p *jsonPathParser) hex4() (rune, error) {
+	if p.pos+4 > len(p.src) {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	value, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	p.pos += 4
+	return rune(value), nil
+}
+
+func (p *jsonPathParser) logicalOr() (logicalExpression, error) {
+	var expressions orExpression
+	for {
+		expression, err := p.logicalAnd()
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, expression)
+		start := p.pos
+		p.skipBlank()
+		if !p.consume("||") {
+			p.pos = start
+			break
+		}
+		p.skipBlank()
+	}
+	if len(expressions) == 1 {
+		return expressions[0], nil
+	}
+	return expressions, nil
+}
+
+func (p *jsonPathParser) logicalAnd() (logicalExpression, error) {
+	var expressions andExpression
+	for {
+		expression, err := p.basic()
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, expression)
+		start := p.pos
+		p.skipBlank()
+		if !p.consume("&&") {
+			p.pos = start
+			break
+		}
+		p.skipBlank()
+	}
+	if len(expressions) == 1 {
+		return expressions[0], nil
+	}
+	return expressions, nil
+}
+
+func (p *jsonPathParser) basic() (logicalExpression, error) {
+	negated := false
+	if p.peek() == '!' && !strings.HasPrefix(p.src[p.pos:], "!=") {
+		p.pos++
+		p.skipBlank()
+		negated = true
+	}
+	var expression logicalExpression
+	if p.consume("(") {
+		p.skipBlank()
+		inner, err := p.logicalOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipBlank()
+		if !p.consume(")") {
+			return nil, p.unexpected()
+		}
+		expression = inner
+	} else {
+		operandStart := p.pos
+		operand, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		operatorStart := p.pos
+		p.skipBlank()
+		operator := p.comparisonOperator()
+		switch {
+		case operator == "=~" && !negated:
+			expression, err = p.regexpComparison(operand, operandStart)
+		case operator != "" && !negated:
+			expression, err = p.comparison(operand, operandStart, operator)
+		case operator != "":
+			p.pos = operatorStart
+			return nil, p.errorf("comparisons must be negated with !(...)")
+		default:
+			p.pos = operatorStart
+			expression, err = p.test(operand, operandStart)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if negated {
+		return notExpression{expression}, nil
+	}
+	return expression, nil
+}
+
+func (p *jsonPathParser) comparisonOperator() string {
+	operators := []string{"==", "!=", "<=", ">=", "<", ">"}
+	if p.compat {
+		operators = append(operators, "=~")
+	}
+	for _, operator := range operators {
+		if p.consume(operator) {
+			return operator
+		}
+	}
+	return ""
+}
+
+// operand parses a literal, a filter query or a function expression
+func (p *jsonPathParser) operand() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		p.pos++
+		segments, err := p.segments()
+		if err != nil {
+			return nil, err
+		}
+		return &filterQuery{relative: c == '@', segments: segments}, nil
+	case c == '\'' || c == '"':
+		value, err := p.stringLiteral()
+		return literal{value}, err
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.numberLiteral()
+	case c >= 'a' && c <= 'z':
+		for _, keyword := range []struct {
+			name  string
+			value interface{}
+		}{{"true", true}, {"false", false}, {"null", nil}} {
+			if strings.HasPrefix(p.src[p.pos:], keyword.name) && !isFunctionNameChar(p.src, p.pos+len(keyword.name)) {
+				p.pos += len(keyword.name)
+				return literal{keyword.value}, nil
+			}
+		}
+		return p.function()
+	}
+	return nil, p.unexpected()
+}
+
+func isFunctionNameChar(src string, pos int) bool {
+	if pos >= len(src) {
+		return false
+	}
+	c := src[pos]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '('
+}
+
+func (p *jsonPathParser) numberLiteral() (interface{}, error) {
+	start := p.pos
+	p.consume("-")
+	digits := p.pos
+	for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+		p.pos++
+	}
+	if p.pos == digits || (p.src[digits] == '0' && p.pos-digits > 1) {
+		p.pos = start
+		return nil, p.errorf("invalid number")
+	}
+	if p.consume(".") {
+		fraction := p.pos
+		for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+		}
+		if p.pos == fraction {
+			return nil, p.errorf("invalid number")
+		}
+	}
+	if p.peek() == 'e' || p.peek() == 'E' {
+		p.pos++
+		if p.peek() == '+' || p.peek() == '-' {
+			p.pos++
+		}
+		exponent := p.pos
+		for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+		}
+		if p.pos == exponent {
+			return nil, p.errorf("invalid number")
+		}
+	}
+	value, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+	if err != nil || math.IsInf(value, 0) {
+		p.pos = start
+		return nil, p.errorf("invalid number")
+	}
+	return literal{value}, nil
+}
+
+func (p *jsonPathParser) function() (interface{}, error) {
+	start := p.pos
+	for isFunctionNameChar(p.src, p.pos) && p.peek() != '(' {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	definition, ok := jsonPathFunctions[name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown function %s", name)
+	}
+	if !p.consume("(") {
+		return nil, p.unexpected()
+	}
+	function := &functionExpression{name: name, definition: definition}
+	for {
+		p.skipBlank()
+		argumentStart := p.pos
+		argument, err := p.argument()
+		if err != nil {
+			return nil, err
+		}
+		if len(function.arguments) >= len(definition.parameters) {
+			p.pos = argumentStart
+			return nil, p.errorf("too many arguments for %s()", name)
+		}
+		if err := p.checkArgument(argument, definition.parameters[len(function.arguments)], argumentStart); err != nil {
+			return nil, err
+		}
+		function.arguments = append(function.arguments, argument)
+		p.skipBlank()
+		if p.consume(")") {
+			break
+		}
+		if !p.consume(",") {
+			return nil, p.unexpected()
+		}
+	}
+	if len(function.arguments) != len(definition.parameters) {
+		return nil, p.errorf("%s() takes %d arguments", name, len(definition.parameters))
+	}
+	return function, nil
+}
+
+// argument parses a literal, filter query, function expression or logical expression
+func (p *jsonPathParser) argument() (interface{}, error) {
+	start := p.pos
+	if p.peek() != '!' && p.peek() != '(' {
+		operand, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		end := p.pos
+		p.skipBlank()
+		if p.peek() == ',' || p.peek() == ')' {
+			p.pos = end
+			return operand, nil
+		}
+		p.pos = start
+	}
+	return p.logicalOr()
+}
+
+// checkArgument enforces the well-typedness rules of RFC 9535 section 2.4.3
+func (p *jsonPathParser) checkArgument(argument interface{}, parameter functionType, start int) error {
+	var ok bool
+	switch argument := argument.(type) {
+	case literal:
+		ok = parameter == valueType
+	case *filterQuery:
+		ok = parameter == nodesType || parameter == logicalType || (parameter == valueType && argument.singular())
+	case *functionExpression:
+		ok = argument.definition.result == parameter || (parameter == logicalType && argument.definition.result == nodesType)
+	case logicalExpression:
+		ok = parameter == logicalType
+	}
+	if !ok {
+		p.pos = start
+		return p.errorf("argument is not of the type the function expects")
+	}
+	return nil
+}
+
+func (p *jsonPathParser) comparableExpression(operand interface{}, start int) (comparableExpression, error) {
+	switch operand := operand.(type) {
+	case literal:
+		return operand, nil
+	case *filterQuery:
+		if operand.singular() {
+			return operand, nil
+		}
+		p.pos = start
+		return nil, p.errorf("queries in comparisons must be singular")
+	case *functionExpression:
+		if operand.definition.result == valueType {
+			return operand, nil
+		}
+		p.pos = start
+		return nil, p.errorf("%s() cannot be compared", operand.name)
+	}
+	p.pos = start
+	return nil, p.errorf("invalid comparison")
+}
+
+func (p *jsonPathParser) comparison(operand interface{}, start int, operator string) (logicalExpression, error) {
+	left, err := p.comparableExpression(operand, start)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	rightStart := p.pos
+	rightOperand, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.comparableExpression(rightOperand, rightStart)
+	if err != nil {
+		return nil, err
+	}
+	return comparisonExpression{left: left, right: right, operator: operator}, nil
+}
+
+// regexpComparison parses the compatibility form @.name =~ /pattern/ as search()
+func (p *jsonPathParser) regexpComparison(operand interface{}, start int) (logicalExpression, error) {
+	value, err := p.comparableExpression(operand, start)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if !p.consume("/") {
+		return nil, p.unexpected()
+	}
+	end := strings.IndexByte(p.src[p.pos:], '/')
+	if end < 0 {
+		return nil, p.errorf("unterminated regular expression")
+	}
+	pattern := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	function := &functionExpression{name: "search", definition: jsonPathFunctions["search"], arguments: []interface{}{value, literal{pattern}}}
+	return functionTest{function}, nil
+}
+
+func (p *jsonPathParser) test(operand interface{}, start int) (logicalExpression, error) {
+	switch operand := operand.(type) {
+	case *filterQuery:
+		return existsExpression{operand}, nil
+	case *functionExpression:
+		if operand.definition.result != valueType {
+			return functionTest{operand}, nil
+		}
+		p.pos = start
+		return nil, p.errorf("the result of %s() must be compared", operand.name)
+	}
+	p.pos = start
+	return nil, p.errorf("literals must be compared")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
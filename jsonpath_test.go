@@ -0,0 +1,185 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// the example document of RFC 9535 section 1.5
+const jsonPathStore = `{ "store": {
+    "book": [
+      { "category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95 },
+      { "category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99 },
+      { "category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99 },
+      { "category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99 }
+    ],
+    "bicycle": { "color": "red", "price": 399 }
+  }
+}`
+
+func queryJSON(t *testing.T, path *JSONPath, document string) string {
+	decoded, err := decodeJSONDocument(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := json.Marshal(path.Query(decoded))
+	return string(result)
+}
+
+func TestJSONPathQueries(t *testing.T) {
+	for _, test := range []struct {
+		expression, document, expected string
+	}{
+		{`$.store.book[*].author`, jsonPathStore, `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{`$..author`, jsonPathStore, `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{`$.store.*`, jsonPathStore, `[{"color":"red","price":399},[{"author":"Nigel Rees","category":"reference","price":8.95,"title":"Sayings of the Century"},{"author":"Evelyn Waugh","category":"fiction","price":12.99,"title":"Sword of Honour"},{"author":"Herman Melville","category":"fiction","isbn":"0-553-21311-3","price":8.99,"title":"Moby Dick"},{"author":"J. R. R. Tolkien","category":"fiction","isbn":"0-395-19395-8","price":22.99,"title":"The Lord of the Rings"}]]`},
+		{`$.store..price`, jsonPathStore, `[399,8.95,12.99,8.99,22.99]`},
+		{`$..book[2].author`, jsonPathStore, `["Herman Melville"]`},
+		{`$..book[2].publisher`, jsonPathStore, `[]`},
+		{`$..book[-1].title`, jsonPathStore, `["The Lord of the Rings"]`},
+		{`$..book[0,1].title`, jsonPathStore, `["Sayings of the Century","Sword of Honour"]`},
+		{`$..book[:2].title`, jsonPathStore, `["Sayings of the Century","Sword of Honour"]`},
+		{`$..book[?@.isbn].title`, jsonPathStore, `["Moby Dick","The Lord of the Rings"]`},
+		{`$..book[?@.price<10].title`, jsonPathStore, `["Sayings of the Century","Moby Dick"]`},
+		{`$..book[?(@.price < 10 && @.category == 'fiction')].title`, jsonPathStore, `["Moby Dick"]`},
+		{`$..book[?!@.isbn || @.author == "Herman Melville"].title`, jsonPathStore, `["Sayings of the Century","Sword of Honour","Moby Dick"]`},
+		{`$..book[?@.price > $.store.bicycle.price].title`, jsonPathStore, `[]`},
+		{`$['store']["bicycle"]['color']`, jsonPathStore, `["red"]`},
+		{`$[1:5:2]`, `[0,1,2,3,4,5]`, `[1,3]`},
+		{`$[5:1:-2]`, `[0,1,2,3,4,5]`, `[5,3]`},
+		{`$[::-1]`, `[0,1,2]`, `[2,1,0]`},
+		{`$[-2:]`, `[0,1,2]`, `[1,2]`},
+		{`$[0:3:0]`, `[0,1,2]`, `[]`},
+		{`$[?@ == null]`, `[null,1]`, `[null]`},
+		{`$[?@.a == @.b]`, `[{"a":1},{"a":1,"b":1},{"b":[1]}]`, `[{"a":1,"b":1}]`},
+		{`$[?@ == 1.0]`, `[1,"1",true]`, `[1]`},
+		{`$[?@ > 'b']`, `["a","c",3]`, `["c"]`},
+		{`$[?length(@) == 2]`, `["ab",[1,2],{"a":1},"é€",3]`, `["ab",[1,2],"é€"]`},
+		{`$[?count(@.*) == 1]`, `[{"a":1},{"a":1,"b":2},[1]]`, `[{"a":1},[1]]`},
+		{`$[?match(@.date, '1974-05-..')]`, `[{"date":"1974-05-01"},{"date":"1974-05-011"},{"date":"1974-05-\n1"}]`, `[{"date":"1974-05-01"}]`},
+		{`$[?search(@, '[bc]')]`, `["abc","d"]`, `["abc"]`},
+		{`$[?value(@..color) == 'red']`, `[{"a":{"color":"red"}},{"color":"blue"}]`, `[{"a":{"color":"red"}}]`},
+		{`$ .a ['b']`, `{"a":{"b":1}}`, `[1]`},
+		{`$["é\t"]`, `{"é\t":1}`, `[1]`},
+		{`$['\'']`, `{"'":1}`, `[1]`},
+		{`$..[0]`, `[[1],{"a":[2]}]`, `[[1],1,2]`},
+	} {
+		path, err := CompileJSONPath(test.expression)
+		if err != nil {
+			t.Errorf("%s: %v\n", test.expression, err)
+			continue
+		}
+		if actual := queryJSON(t, path, test.document); actual != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.expression, actual, test.expected)
+		}
+	}
+}
+
+func TestInvalidJSONPaths(t *testing.T) {
+	for _, expression := range []string{
+		``, `.a`, `$.`, `$.a.`, `$a`, ` $.a`, `$.a `, `$..`, `$. a`,
+		`$[`, `$[]`, `$[01]`, `$[-0]`, `$[9007199254740992]`, `$[1:2:3:4]`, `$['a'`, `$['\q']`,
+		`$.databases-for-postgresql`, `$.1a`,
+		`$[?@.a == 1 &&]`, `$[?true]`, `$[?1 == 1 ||]`, `$[?@.* == 1]`, `$[?!@.a == 1]`,
+		`$[?length(@)]`, `$[?match(@, 'a') == true]`, `$[?count(1) == 1]`, `$[?length(@.*) == 1]`,
+		`$[?unknown(@)]`, `$[?length(@, 1) == 1]`, `$[@.a]`, `$[?@.a =~ /a/]`, `$[?@.a == [1]]`,
+	} {
+		if _, err := CompileJSONPath(expression); err == nil {
+			t.Errorf("Expected %q to be invalid\n", expression)
+		} else if _, ok := err.(*JSONPathError); !ok {
+			t.Errorf("Expected a JSONPathError for %q, got %v\n", expression, err)
+		}
+	}
+}
+
+func TestJSONPathCompatibility(t *testing.T) {
+	document := `{"databases-for-postgresql":[{"name":"db1","port":5432},{"name":"db2","port":5433}],"0":"zero"}`
+	for _, test := range []struct {
+		expression, expected string
+	}{
+		{`$.databases-for-postgresql[0].port`, `5432`},
+		{`$.databases-for-postgresql[@.name=='db2'].port`, `[5433]`},
+		{`$.databases-for-postgresql[?(@.name == 'db1')].port`, `[5432]`},
+		{`$.databases-for-postgresql[?(@.name =~ /2$/)].name`, `["db2"]`},
+		{`$.0`, `zero`},
+		{`$.databases-for-postgresql[*].name`, `["db1","db2"]`},
+		{`$.databases-for-postgresql[0:1]`, `[{"name":"db1","port":5432}]`},
+	} {
+		path, err := compileJSONPath(test.expression, true)
+		if err != nil {
+			t.Errorf("%s: %v\n", test.expression, err)
+			continue
+		}
+		decoded, _ := decodeJSONDocument(document)
+		if actual, ok := jsonPathResult(path, decoded); !ok || actual != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.expression, actual, test.expected)
+		}
+	}
+}
+
+func TestProcessJSONPath(t *testing.T) {
+	document := `{"a":{"b":12345678901234567890,"c":null,"d":true,"e":"text"},"list":[1,2]}`
+	for _, test := range []struct {
+		expression, expected string
+		ok                   bool
+	}{
+		{`$.a.b`, `12345678901234567890`, true},
+		{`$.a.c`, ``, false},
+		{`$.a.d`, `true`, true},
+		{`$.a.e`, `text`, true},
+		{`$.a.missing`, ``, false},
+		{`$.list`, `[1,2]`, true},
+		{`$.list[*]`, `[1,2]`, true},
+		{`$.list[?@ > 1]`, `[2]`, true},
+		{`$.list[?@ > 5]`, ``, false},
+		{`$.list[0:1]`, `[1]`, true},
+		{`$asdasd`, ``, false},
+	} {
+		if actual, ok := processJSONPath(document, test.expression); ok != test.ok || actual != test.expected {
+			t.Errorf("%s Got: \t%s %v\n Wanted: \t%s %v\n", test.expression, actual, ok, test.expected, test.ok)
+		}
+	}
+	if _, ok := processJSONPath(`{"a":`, `$.a`); ok {
+		t.Errorf("Expected invalid JSON not to resolve\n")
+	}
+}
+
+func TestJSONPathModes(t *testing.T) {
+	t.Setenv("JSONPATH_VAR", `{"service-name":{"a:b":"colon","list":[1,2,3]}}`)
+
+	Initialize("server/config/jsonpath/mappings.json")
+	testString, _ := GetString("jsonpath_var1")
+	if testString != "" {
+		t.Errorf("Compatibility syntax should be rejected in rfc9535 mode, got: %s\n", testString)
+	}
+	testString, _ = GetString("jsonpath_var2")
+	if testString != "colon" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "colon")
+	}
+	testString, _ = GetString("jsonpath_var3")
+	if testString != "[2,3]" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "[2,3]")
+	}
+
+	Initialize("server/config/mappings.json")
+	if value, ok := processJSONPath(os.Getenv("JSONPATH_VAR"), `$.service-name.list[0]`); !ok || value != "1" {
+		t.Errorf("Compatibility mode should be the default, got: %s\n", value)
+	}
+}
@@ -0,0 +1,408 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// kvStandIn is an in-process key-value store with a revision that grows on every write.
+// Waiters are woken up by writes.
+type kvStandIn struct {
+	mutex    sync.Mutex
+	values   map[string]string
+	modified map[string]uint64
+	revision uint64
+	changed  chan struct{}
+}
+
+func newKVStandIn(values map[string]string) *kvStandIn {
+	store := &kvStandIn{values: make(map[string]string), modified: make(map[string]uint64), changed: make(chan struct{})}
+	for key, value := range values {
+		store.put(key, value)
+	}
+	return store
+}
+
+func (s *kvStandIn) put(key, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.revision++
+	s.values[key] = value
+	s.modified[key] = s.revision
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// lookup returns the keys in the range, the highest revision they were modified at and a
+// channel closed by the next write
+func (s *kvStandIn) lookup(key string, prefix bool) (map[string]string, uint64, <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values := make(map[string]string)
+	var index uint64
+	for name, value := range s.values {
+		if name == key || (prefix && strings.HasPrefix(name, key)) {
+			values[name] = value
+			if s.modified[name] > index {
+				index = s.modified[name]
+			}
+		}
+	}
+	return values, index, s.changed
+}
+
+// newConsulStandIn serves the KV endpoint of the Consul HTTP API, including blocking queries
+func newConsulStandIn(t *testing.T, store *kvStandIn) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != "consul-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("ACL not found"))
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		values, index, changed := store.lookup(key, r.URL.Query().Get("recurse") == "true")
+		for wait > 0 && index <= wait {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			values, index, changed = store.lookup(key, r.URL.Query().Get("recurse") == "true")
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		if len(values) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		entries := []map[string]interface{}{}
+		for _, name := range sortedKeys(values) {
+			entries = append(entries, map[string]interface{}{"Key": name, "Value": base64.StdEncoding.EncodeToString([]byte(values[name]))})
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv(CONSUL_HTTP_ADDR_ENV, strings.TrimPrefix(server.URL, "http://"))
+	t.Setenv(CONSUL_HTTP_TOKEN_ENV, "consul-token")
+	return server
+}
+
+// newEtcdStandIn serves the range, watch and authenticate endpoints of the etcd v3 JSON gateway
+func newEtcdStandIn(t *testing.T, store *kvStandIn) *httptest.Server {
+	decode := func(value string) string {
+		decoded, _ := base64.StdEncoding.DecodeString(value)
+		return string(decoded)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		if r.URL.Path == "/v3/auth/authenticate" {
+			if request["name"] != "root" || request["password"] != "etcd-password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"authentication failed","code":16,"message":"authentication failed"}`))
+				return
+			}
+			w.Write([]byte(`{"token":"etcd-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "etcd-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"user name is empty","code":16,"message":"user name is empty"}`))
+			return
+		}
+		if r.URL.Path == "/v3/watch" {
+			request = request["create_request"].(map[string]interface{})
+		}
+		key := decode(request["key"].(string))
+		_, prefix := request["range_end"]
+		values, index, changed := store.lookup(key, prefix)
+		if r.URL.Path == "/v3/kv/range" {
+			kvs := []map[string]string{}
+			for _, name := range sortedKeys(values) {
+				kvs = append(kvs, map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(name)), "value": base64.StdEncoding.EncodeToString([]byte(values[name]))})
+			}
+			store.mutex.Lock()
+			revision := store.revision
+			store.mutex.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"header": map[string]string{"revision": strconv.FormatUint(revision, 10)}, "kvs": kvs, "count": strconv.Itoa(len(kvs))})
+			return
+		}
+		start, _ := strconv.ParseUint(request["start_revision"].(string), 10, 64)
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+		w.(http.Flusher).Flush()
+		for index < start {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			_, index, changed = store.lookup(key, prefix)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"events": []map[string]string{{"type": "PUT"}}}})
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv(ETCD_ENDPOINTS_ENV, "http://127.0.0.1:1,"+server.URL)
+	t.Setenv(ETCD_USER_ENV, "root:etcd-password")
+	return server
+}
+
+func newKVStores(t *testing.T) (*kvStandIn, *kvStandIn) {
+	consul := newKVStandIn(map[string]string{
+		"config/app/db/host": "db.internal",
+		"config/app/db/port": "5432",
+		"config/app/name":    "my-app",
+		"config/app/json":    `{"feature":{"enabled":true}}`,
+		"config/other":       "other",
+	})
+	etcd := newKVStandIn(map[string]string{
+		"/config/app/credentials": `{"username":"admin","password":"etcd-secret"}`,
+		"/config/app/region":      "us-south",
+	})
+	newConsulStandIn(t, consul)
+	newEtcdStandIn(t, etcd)
+	return consul, etcd
+}
+
+func TestKVStoreSearchPatterns(t *testing.T) {
+	newKVStores(t)
+	for _, test := range []struct {
+		pattern, expected string
+	}{
+		{"consul:config/app/db/host", "db.internal"},
+		{"consul:config/app/json:$.feature.enabled", "true"},
+		{"consul:config/app/", `{"db":{"host":"db.internal","port":"5432"},"json":"{\"feature\":{\"enabled\":true}}","name":"my-app"}`},
+		{"consul:config/app/:$.db.port", "5432"},
+		{"etcd:/config/app/credentials", `{"username":"admin","password":"etcd-secret"}`},
+		{"etcd:/config/app/credentials:$.password", "etcd-secret"},
+		{"etcd:/config/app/:$.region", "us-south"},
+		{"etcd:/config/app/", `{"credentials":"{\"username\":\"admin\",\"password\":\"etcd-secret\"}","region":"us-south"}`},
+	} {
+		if value, ok := processSearchPattern(context.Background(), "kv", test.pattern, patternOptions{}); !ok || value != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.pattern, value, test.expected)
+		}
+	}
+	for _, pattern := range []string{"consul:config/missing", "consul:missing/", "etcd:/config/missing", "etcd:/missing/", "consul:config/app/name:$.name", "etcd:"} {
+		if _, ok := processSearchPattern(context.Background(), "kv", pattern, patternOptions{}); ok {
+			t.Errorf("Expected %s not to resolve\n", pattern)
+		}
+	}
+
+	t.Setenv(CONSUL_HTTP_TOKEN_ENV, "wrong-token")
+	if _, _, err := readConsulKey(context.Background(), "config/app/name"); err == nil || err.Error() != "request failed with status 403: ACL not found" {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "request failed with status 403: ACL not found")
+	}
+	t.Setenv(ETCD_USER_ENV, "root:wrong")
+	if _, _, err := readEtcdKey(context.Background(), "/config/app/region"); err == nil || err.Error() != "request failed with status 401: authentication failed" {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "request failed with status 401: authentication failed")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	consul, etcd := newKVStores(t)
+	Initialize("server/config/kv_store/mappings.json")
+	if value, _ := GetString("kv_template"); value != "db.internal:etcd-secret" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "db.internal:etcd-secret")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, func(name string) { changes <- name })
+	}()
+	waitForChanges := func(expected ...string) {
+		names := []string{}
+		for len(names) < len(expected) {
+			select {
+			case name := <-changes:
+				names = append(names, name)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Timed out waiting for %v, got %v\n", expected, names)
+			}
+		}
+		sort.Strings(names)
+		sort.Strings(expected)
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Errorf("Got: \t%v\n Wanted: \t%v\n", names, expected)
+		}
+	}
+
+	consul.put("config/app/db/host", "db2.internal")
+	waitForChanges("consul_var1", "consul_var2", "kv_template")
+	if value, _ := GetString("kv_template"); value != "db2.internal:etcd-secret" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "db2.internal:etcd-secret")
+	}
+
+	etcd.put("/config/app/credentials", `{"username":"admin","password":"rotated"}`)
+	waitForChanges("etcd_var1", "kv_template")
+	if value, _ := GetString("etcd_var1"); value != "rotated" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "rotated")
+	}
+
+	// keys outside of the watched ones do not reload anything
+	consul.put("config/other", "changed")
+	select {
+	case name := <-changes:
+		t.Errorf("Unexpected reload of %s\n", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", err, context.Canceled)
+	}
+}
+
+func TestWatchAfterReload(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	consul, etcd := newKVStores(t)
+	consul.put("config/app/json", `{"feature":{"enabled":true,"name":"beta"}}`)
+	mappingsFile := t.TempDir() + "/mappings.json"
+	ioutil.WriteFile(mappingsFile, []byte(`{
+		"consul_var1": {"searchPatterns": ["consul:config/app/db/host"]},
+		"kv_stale": {"template": "stale-${consul_var1}"}
+	}`), 0644)
+	Initialize(mappingsFile)
+	ioutil.WriteFile(mappingsFile, []byte(`{
+		"consul_var1": {"searchPatterns": ["consul:config/app/db/host"]},
+		"kv_feature": {"searchPatterns": ["consul:config/app/json:$.feature.name", "etcd:/config/app/region"]}
+	}`), 0644)
+	Initialize(mappingsFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan string, 10)
+	go Watch(ctx, func(name string) { changes <- name })
+	waitForChange := func(expected, value string) {
+		select {
+		case name := <-changes:
+			if actual, _ := GetString(name); name != expected || actual != value {
+				t.Errorf("Got: \t%s %s\n Wanted: \t%s %s\n", name, actual, expected, value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s\n", expected)
+		}
+	}
+
+	// templates of the earlier load are not resolved again
+	consul.put("config/app/db/host", "db2.internal")
+	waitForChange("consul_var1", "db2.internal")
+
+	// once the mapping falls back to etcd, the etcd key is watched
+	consul.put("config/app/json", `{"feature":{"enabled":true}}`)
+	waitForChange("kv_feature", "us-south")
+	etcd.put("/config/app/region", "eu-de")
+	waitForChange("kv_feature", "eu-de")
+	select {
+	case name := <-changes:
+		t.Errorf("Unexpected reload of %s\n", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if value, _ := loadedString("kv_stale"); value == "stale-db2.internal" {
+		t.Errorf("Expected the template of the earlier load not to be resolved again\n")
+	}
+}
+
+func TestWatchStops(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	consul, _ := newKVStores(t)
+	consul.put("config/app/json", `{"feature":{"enabled":true,"name":"beta"}}`)
+	t.Setenv("KV_FEATURE_NAME", "fallback")
+	mappingsFile := t.TempDir() + "/mappings.json"
+	ioutil.WriteFile(mappingsFile, []byte(`{
+		"consul_var1": {"searchPatterns": ["consul:config/app/db/host"]},
+		"kv_feature": {"searchPatterns": ["consul:config/app/json:$.feature.name", "env:KV_FEATURE_NAME"]}
+	}`), 0644)
+	Initialize(mappingsFile)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	changes := make(chan string, 10)
+	done := make(chan error)
+	go func() { done <- Watch(ctx, func(name string) { changes <- name }) }()
+
+	// a mapping falling back to an environment variable is no longer watched
+	consul.put("config/app/json", `{"feature":{"enabled":true}}`)
+	select {
+	case name := <-changes:
+		if name != "kv_feature" {
+			t.Errorf("Got: \t%s\n Wanted: \t%s\n", name, "kv_feature")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for kv_feature\n")
+	}
+
+	// a mapping left out of the last Initialize is no longer watched
+	ioutil.WriteFile(mappingsFile, []byte(`{"kv_feature": {"searchPatterns": ["env:KV_FEATURE_NAME"]}}`), 0644)
+	Initialize(mappingsFile)
+	consul.put("config/app/db/host", "db2.internal")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Watch to return once no mapping is watched, got: %v\n", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for Watch to return\n")
+	}
+	select {
+	case name := <-changes:
+		t.Errorf("Unexpected reload of %s\n", name)
+	default:
+	}
+}
+
+func TestWatchConsulWithoutIndex(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`[{"Key": "config/app/name", "Value": "bXktYXBw"}]`))
+	}))
+	defer server.Close()
+	t.Setenv(CONSUL_HTTP_ADDR_ENV, strings.TrimPrefix(server.URL, "http://"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := watchConsulKey(ctx, []string{PREFIX_PATTERN_CONSUL, "config/app/name"}); err == nil || ctx.Err() != nil {
+		t.Errorf("Expected the watch to fail without an index, got: %v\n", err)
+	}
+	if atomic.LoadInt32(&requests) > 2 {
+		t.Errorf("Expected at most 2 requests, got %d\n", requests)
+	}
+}
+
+func TestWatchWithoutSources(t *testing.T) {
+	Initialize("server/config/mappings.json")
+	if err := Watch(context.Background(), nil); err != nil {
+		t.Errorf("Expected Watch to return right away without consul: or etcd: mappings, got: %v\n", err)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
+	"strings"
+	"sync"
+)
+
+// RESOLUTION_LAZY, set as "resolution" in a mappings file, makes Initialize only parse the
+// mappings and resolve each of them on the first Get call that needs it
+const RESOLUTION_LAZY = "lazy"
+
+var lazyMutex sync.Mutex
+var lazyMode bool
+
+// lazyPending holds the mappings of the current load that were not resolved yet. Version 2
+// files add the mapping name, which resolves all of its keys at once.
+var lazyPending = make(map[string]bool)
+
+// lazyTemplates holds the template mappings of the current load
+var lazyTemplates = make(map[string]gjson.Result)
+var lazyGroup singleflight.Group
+
+// resetLazy starts a new load, forgetting the pending mappings of the previous one
+func resetLazy(lazy bool) {
+	lazyMutex.Lock()
+	defer lazyMutex.Unlock()
+	lazyMode = lazy
+	lazyPending = make(map[string]bool)
+	lazyTemplates = make(map[string]gjson.Result)
+}
+
+func lazyResolution() bool {
+	lazyMutex.Lock()
+	defer lazyMutex.Unlock()
+	return lazyMode
+}
+
+// deferMapping records a mapping to resolve on first use, dropping the value and status a
+// previous load left for it
+func deferMapping(name string, definition mappingDefinition) {
+	lazyMutex.Lock()
+	if definition.group != "" {
+		lazyPending[definition.group] = true
+	} else {
+		lazyPending[name] = true
+	}
+	if definition.template {
+		lazyTemplates[name] = definition.config
+	}
+	lazyMutex.Unlock()
+
+	mappingsMutex.Lock()
+	if definition.group != "" {
+		delete(loadedMappings, definition.group)
+	} else {
+		delete(loadedMappings, name)
+	}
+	mappingsMutex.Unlock()
+	statusMutex.Lock()
+	delete(mappingStatuses, name)
+	statusMutex.Unlock()
+}
+
+func lazyPendingMapping(name string) bool {
+	lazyMutex.Lock()
+	defer lazyMutex.Unlock()
+	return lazyPending[name]
+}
+
+func mappingLoaded(name string) bool {
+	mappingsMutex.RLock()
+	defer mappingsMutex.RUnlock()
+	_, ok := loadedMappings[name]
+	return ok
+}
+
+// resolveLazily resolves a pending mapping the first time it is requested. Concurrent
+// requests for the same mapping wait for a single resolution. A resolution aborted by the
+// context of the caller that started it leaves the mapping pending, so callers whose
+// context is still alive start another one.
+func resolveLazily(ctx context.Context, name string) {
+	for lazyPendingMapping(name) && !mappingLoaded(name) && ctx.Err() == nil {
+		done := lazyGroup.DoChan(name, func() (interface{}, error) {
+			if lazyPendingMapping(name) && !mappingLoaded(name) {
+				resolvePending(ctx, name)
+			}
+			return nil, nil
+		})
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func resolvePending(ctx context.Context, name string) {
+	lazyMutex.Lock()
+	templates := templateClosure(name, lazyTemplates)
+	lazyMutex.Unlock()
+
+	if len(templates) > 0 {
+		resolveTemplates(ctx, templates)
+		finishPending(ctx, sortedKeys(templates)...)
+		return
+	}
+
+	// definitions of earlier loads are kept for Watch, only those of the last one count here
+	definitionsMutex.RLock()
+	definition, ok := mappingDefinitions[name]
+	ok = ok && currentMappings[name]
+	entries := make(map[string]mappingDefinition)
+	if !ok {
+		for key, entry := range mappingDefinitions {
+			if entry.group == name && currentMappings[key] {
+				entries[key] = entry
+			}
+		}
+	}
+	definitionsMutex.RUnlock()
+
+	if ok {
+		processMapping(ctx, name, definition.config)
+	}
+	for _, key := range sortedKeys(entries) {
+		processMappingV2Entry(ctx, name, entries[key].key, entries[key].config)
+	}
+	finishPending(ctx, name)
+}
+
+// finishPending memoizes the outcome of a resolution. Mappings that did not resolve because
+// the context ended stay pending.
+func finishPending(ctx context.Context, names ...string) {
+	aborted := ctx.Err() != nil
+	lazyMutex.Lock()
+	defer lazyMutex.Unlock()
+	for _, name := range names {
+		if !aborted || mappingLoaded(name) {
+			delete(lazyPending, name)
+		}
+	}
+}
+
+// templateClosure returns the template name and the templates it references, directly or
+// through other templates, so that they are resolved together in dependency order
+func templateClosure(name string, templates map[string]gjson.Result) map[string]gjson.Result {
+	closure := make(map[string]gjson.Result)
+	var visit func(name string)
+	visit = func(name string) {
+		config, isTemplate := templates[name]
+		if _, seen := closure[name]; seen || !isTemplate {
+			return
+		}
+		closure[name] = config
+		for _, ref := range templateReferences(config.Get("template").String()) {
+			visit(ref)
+		}
+	}
+	visit(name)
+	return closure
+}
+
+// Preload resolves the named mappings right away, or every mapping still pending in lazy
+// mode when no names are given, and returns an error listing the ones that did not resolve.
+// It lets startup-critical mappings fail fast in lazy mode; otherwise it only reports.
+func Preload(names ...string) error {
+	return PreloadContext(context.Background(), names...)
+}
+
+// PreloadContext is Preload giving up when ctx ends. The mappings the context left
+// unresolved are reported as an IncompleteError.
+func PreloadContext(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		lazyMutex.Lock()
+		names = sortedKeys(lazyPending)
+		lazyMutex.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	resolved := make([]bool, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			_, resolved[i] = GetStringContext(ctx, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	missing := []string{}
+	for i, name := range names {
+		if !resolved[i] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	} else if ctx.Err() != nil {
+		return &IncompleteError{Mappings: missing, Err: ctx.Err()}
+	}
+	return fmt.Errorf("mappings not resolved: %s", strings.Join(missing, ", "))
+}
@@ -0,0 +1,148 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func lazyAttempts(name string) uint64 {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	return resolutionAttempts[attemptKey{mapping: name, source: PREFIX_PATTERN_ENV, resolved: true}] +
+		resolutionAttempts[attemptKey{mapping: name, source: PREFIX_PATTERN_ENV, resolved: false}]
+}
+
+func TestLazyResolution(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	os.Unsetenv("LAZY_VAR1")
+	os.Unsetenv("LAZY_VAR2")
+	Initialize("server/config/lazy/mappings.json")
+	if mappingResolved("lazy_var1") || mappingLoaded("lazy_var1") {
+		t.Errorf("Expected lazy_var1 not to be resolved by Initialize\n")
+	}
+
+	// set after Initialize, so only a lazy resolution can find them
+	t.Setenv("LAZY_VAR1", "value1")
+	t.Setenv("LAZY_VAR2", "value2")
+	testString, _ := GetString("lazy_var1")
+	if testString != "value1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "value1")
+	}
+	if !mappingResolved("lazy_var1") {
+		t.Errorf("Expected the status of lazy_var1 to be recorded\n")
+	}
+
+	// templates resolve the mappings and templates they reference
+	testString, _ = GetString("lazy_template")
+	if testString != "value2/base-value1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "value2/base-value1")
+	}
+
+	// values are memoized
+	t.Setenv("LAZY_VAR1", "changed")
+	testString, _ = GetString("lazy_var1")
+	if testString != "value1" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "value1")
+	}
+	if _, ok := GetString("lazy_missing"); ok {
+		t.Errorf("Expected lazy_missing not to resolve\n")
+	}
+	before := lazyAttempts("lazy_missing")
+	GetString("lazy_missing")
+	if after := lazyAttempts("lazy_missing"); after != before {
+		t.Errorf("Expected an unresolved mapping not to be resolved again, attempts went from %d to %d\n", before, after)
+	}
+}
+
+func TestLazyResolutionConcurrent(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	t.Setenv("LAZY_VAR1", "value1")
+	Initialize("server/config/lazy/mappings.json")
+	before := lazyAttempts("lazy_var1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, ok := GetString("lazy_var1"); !ok || value != "value1" {
+				t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "value1")
+			}
+		}()
+	}
+	wg.Wait()
+	if attempts := lazyAttempts("lazy_var1") - before; attempts != 1 {
+		t.Errorf("Expected lazy_var1 to be resolved once, got %d resolutions\n", attempts)
+	}
+}
+
+func TestLazyResolutionV2(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	Initialize("server/config/lazy/v2/mappings.json")
+	t.Setenv("LAZY_GROUP_URL", "https://lazy")
+	t.Setenv("LAZY_GROUP_APIKEY", "lazy-apikey")
+	testString, _ := GetString("lazy_group")
+	if testString != `{"apikey":"lazy-apikey","url":"https://lazy"}` {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, `{"apikey":"lazy-apikey","url":"https://lazy"}`)
+	}
+}
+
+func TestLazyResolutionAfterReload(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	t.Setenv("LAZY_GROUP_URL", "https://lazy")
+	t.Setenv("LAZY_GROUP_APIKEY", "lazy-apikey")
+	t.Setenv("LAZY_GROUP_OLD", "old")
+	dir := t.TempDir()
+	ioutil.WriteFile(dir+"/v1.json", []byte(`{"lazy_group": {"searchPatterns": ["env:LAZY_GROUP_OLD"]}}`), 0644)
+	ioutil.WriteFile(dir+"/v2.json", []byte(`{"version": 2, "lazy_group": {"token": {"searchPatterns": ["env:LAZY_GROUP_OLD"]}}}`), 0644)
+
+	// neither the version 1 mapping nor the keys of another version 2 file are resolved again
+	for _, file := range []string{dir + "/v1.json", dir + "/v2.json"} {
+		Initialize(file)
+		Initialize("server/config/lazy/v2/mappings.json")
+		if testString, _ := GetString("lazy_group"); testString != `{"apikey":"lazy-apikey","url":"https://lazy"}` {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", file, testString, `{"apikey":"lazy-apikey","url":"https://lazy"}`)
+		}
+	}
+}
+
+func TestPreload(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	os.Unsetenv("LAZY_MISSING")
+	t.Setenv("LAZY_VAR1", "value1")
+	t.Setenv("LAZY_VAR2", "value2")
+	Initialize("server/config/lazy/mappings.json")
+
+	if err := Preload("lazy_var1", "lazy_template"); err != nil {
+		t.Errorf("Expected the mappings to preload, got: %v\n", err)
+	}
+	if !mappingLoaded("lazy_var1") || !mappingLoaded("lazy_base") {
+		t.Errorf("Expected Preload to resolve the mappings and the templates they reference\n")
+	}
+
+	err := Preload()
+	if err == nil || err.Error() != "mappings not resolved: lazy_missing" {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "mappings not resolved: lazy_missing")
+	}
+	if lazyPendingMapping("lazy_var2") {
+		t.Errorf("Expected Preload without names to resolve every pending mapping\n")
+	}
+}
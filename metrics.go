@@ -0,0 +1,109 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const METRICS_NAMESPACE = "ibmcloudenv"
+
+var (
+	resolutionAttemptsDesc = prometheus.NewDesc(
+		METRICS_NAMESPACE+"_resolution_attempts_total",
+		"Search pattern resolution attempts by mapping, source prefix and outcome.",
+		[]string{"mapping", "source", "outcome"}, nil)
+	mappingResolvedDesc = prometheus.NewDesc(
+		METRICS_NAMESPACE+"_mapping_resolved",
+		"Whether a mapping resolved (1) or not (0), labelled with the source prefix that won.",
+		[]string{"mapping", "source"}, nil)
+	unresolvedMappingsDesc = prometheus.NewDesc(
+		METRICS_NAMESPACE+"_unresolved_mappings",
+		"Number of mappings that did not resolve.",
+		nil, nil)
+	loadDurationDesc = prometheus.NewDesc(
+		METRICS_NAMESPACE+"_load_duration_seconds",
+		"Duration of the last successful load of a mappings file.",
+		nil, nil)
+	lastLoadDesc = prometheus.NewDesc(
+		METRICS_NAMESPACE+"_last_successful_load_timestamp_seconds",
+		"Unix time of the last successful load of a mappings file.",
+		nil, nil)
+	loadsDesc = prometheus.NewDesc(
+		METRICS_NAMESPACE+"_loads_total",
+		"Successful loads and reloads of mappings files.",
+		nil, nil)
+	loadFailuresDesc = prometheus.NewDesc(
+		METRICS_NAMESPACE+"_load_failures_total",
+		"Loads of mappings files that failed.",
+		nil, nil)
+)
+
+type metricsCollector struct{}
+
+// NewMetricsCollector returns a Prometheus collector exposing resolution and load metrics.
+// Register it once, e.g. prometheus.MustRegister(IBMCloudEnv.NewMetricsCollector()).
+// ibmcloudenv_mapping_resolved{source="file"} can be used to alert on fallback
+// credentials being used in production.
+func NewMetricsCollector() prometheus.Collector {
+	return metricsCollector{}
+}
+
+func (metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- resolutionAttemptsDesc
+	ch <- mappingResolvedDesc
+	ch <- unresolvedMappingsDesc
+	ch <- loadDurationDesc
+	ch <- lastLoadDesc
+	ch <- loadsDesc
+	ch <- loadFailuresDesc
+}
+
+func (metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+
+	for key, count := range resolutionAttempts {
+		outcome := "not_found"
+		if key.resolved {
+			outcome = "resolved"
+		}
+		ch <- prometheus.MustNewConstMetric(resolutionAttemptsDesc, prometheus.CounterValue, float64(count), key.mapping, key.source, outcome)
+	}
+
+	// the statuses only hold the mappings of the last Initialize
+	unresolved := 0
+	for name, status := range mappingStatuses {
+		value := 0.0
+		if status.Resolved {
+			value = 1
+		} else {
+			unresolved++
+		}
+		ch <- prometheus.MustNewConstMetric(mappingResolvedDesc, prometheus.GaugeValue, value, name, status.Source)
+	}
+	ch <- prometheus.MustNewConstMetric(unresolvedMappingsDesc, prometheus.GaugeValue, float64(unresolved))
+
+	ch <- prometheus.MustNewConstMetric(loadDurationDesc, prometheus.GaugeValue, loadStatus.LastDuration.Seconds())
+	lastLoad := 0.0
+	if !loadStatus.LastLoad.IsZero() {
+		lastLoad = float64(loadStatus.LastLoad.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(lastLoadDesc, prometheus.GaugeValue, lastLoad)
+	ch <- prometheus.MustNewConstMetric(loadsDesc, prometheus.CounterValue, float64(loadStatus.Loads))
+	ch <- prometheus.MustNewConstMetric(loadFailuresDesc, prometheus.CounterValue, float64(loadStatus.Failures))
+}
@@ -0,0 +1,110 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// gatherMetrics returns every collected sample keyed as name{label="value",...}
+func gatherMetrics(t *testing.T) map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewMetricsCollector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := []string{}
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName()+`="`+label.GetValue()+`"`)
+			}
+			sort.Strings(labels)
+			value := metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+			samples[family.GetName()+"{"+strings.Join(labels, ",")+"}"] = value
+		}
+	}
+	return samples
+}
+
+func TestMappingMetrics(t *testing.T) {
+	setEnvVariable()
+	samples := gatherMetrics(t)
+
+	expected := map[string]float64{
+		`ibmcloudenv_mapping_resolved{mapping="env_var1",source="env"}`:   1,
+		`ibmcloudenv_mapping_resolved{mapping="file_var1",source="file"}`: 1,
+		`ibmcloudenv_mapping_resolved{mapping="bad_var3",source=""}`:      0,
+	}
+	for key, value := range expected {
+		if sample, ok := samples[key]; !ok || sample != value {
+			t.Errorf("Got: \t%s %v\n Wanted: \t%v\n", key, sample, value)
+		}
+	}
+	if samples["ibmcloudenv_unresolved_mappings{}"] < 1 {
+		t.Errorf("Expected unresolved mappings to be counted\n")
+	}
+}
+
+func TestResolutionAttemptMetrics(t *testing.T) {
+	setEnvVariable()
+	samples := gatherMetrics(t)
+	for _, key := range []string{
+		`ibmcloudenv_resolution_attempts_total{mapping="env_var1",outcome="resolved",source="env"}`,
+		`ibmcloudenv_resolution_attempts_total{mapping="bad_var1",outcome="not_found",source="env"}`,
+		`ibmcloudenv_resolution_attempts_total{mapping="bad_var3",outcome="not_found",source="file"}`,
+	} {
+		if samples[key] < 1 {
+			t.Errorf("Attempt %s not counted\n", key)
+		}
+	}
+}
+
+func TestLoadMetrics(t *testing.T) {
+	setEnvVariable()
+	samples := gatherMetrics(t)
+	if samples["ibmcloudenv_loads_total{}"] < 1 || samples["ibmcloudenv_load_failures_total{}"] < 1 {
+		t.Errorf("Expected successful and failed loads: %v %v\n", samples["ibmcloudenv_loads_total{}"], samples["ibmcloudenv_load_failures_total{}"])
+	}
+	if samples["ibmcloudenv_last_successful_load_timestamp_seconds{}"] == 0 {
+		t.Errorf("Last successful load timestamp not set\n")
+	}
+}
+
+func TestMappingMetricsAfterReload(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	setEnvVariable()
+	Initialize("server/config/template/mappings.json")
+	samples := gatherMetrics(t)
+	if _, ok := samples[`ibmcloudenv_mapping_resolved{mapping="bad_var3",source=""}`]; ok {
+		t.Errorf("Expected no gauge for a mapping of the previous mappings file\n")
+	}
+	unresolved := 0
+	for _, status := range GetMappingStatuses() {
+		if !status.Resolved {
+			unresolved++
+		}
+	}
+	if samples["ibmcloudenv_unresolved_mappings{}"] != float64(unresolved) {
+		t.Errorf("Got: \t%v\n Wanted: \t%d\n", samples["ibmcloudenv_unresolved_mappings{}"], unresolved)
+	}
+}
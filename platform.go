@@ -0,0 +1,90 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"github.com/tidwall/gjson"
+	"os"
+	"strings"
+)
+
+const PLATFORM_CLOUDFOUNDRY = "cloudfoundry"
+const PLATFORM_KUBERNETES = "kubernetes"
+const PLATFORM_CODE_ENGINE = "codeengine"
+const PLATFORM_CLOUD_FUNCTIONS = "functions"
+const PLATFORM_LOCAL = "local"
+
+// PLATFORM_OVERRIDE_ENV forces the value returned by Platform when set
+const PLATFORM_OVERRIDE_ENV = "IBM_CLOUD_ENV_PLATFORM"
+
+var kubernetesServiceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Platform returns the runtime platform the application is running on.
+// Code Engine and Cloud Functions are checked before Kubernetes since both
+// run on top of it and expose the Kubernetes variables as well.
+func Platform() string {
+	if platform, ok := os.LookupEnv(PLATFORM_OVERRIDE_ENV); ok && platform != "" {
+		return strings.ToLower(platform)
+	}
+	if envExists("CE_APP", "CE_JOB", "CE_SUBDOMAIN") {
+		return PLATFORM_CODE_ENGINE
+	}
+	if envExists("__OW_ACTION_NAME", "__OW_API_HOST") {
+		return PLATFORM_CLOUD_FUNCTIONS
+	}
+	if envExists("VCAP_APPLICATION") {
+		return PLATFORM_CLOUDFOUNDRY
+	}
+	if envExists("KUBERNETES_SERVICE_HOST") {
+		return PLATFORM_KUBERNETES
+	}
+	if _, err := os.Stat(kubernetesServiceAccountPath); err == nil {
+		return PLATFORM_KUBERNETES
+	}
+	return PLATFORM_LOCAL
+}
+
+func envExists(names ...string) bool {
+	for _, name := range names {
+		if _, ok := os.LookupEnv(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionMatches evaluates the "when" block of a search pattern object.
+// A missing condition always matches; "platform" may be a string or an array.
+func conditionMatches(when gjson.Result) bool {
+	if !when.Exists() {
+		return true
+	}
+	platform := when.Get("platform")
+	if !platform.Exists() {
+		return true
+	}
+	current := Platform()
+	if platform.IsArray() {
+		for _, p := range platform.Array() {
+			if strings.ToLower(p.String()) == current {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.ToLower(platform.String()) == current
+}
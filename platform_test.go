@@ -0,0 +1,95 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"os"
+	"testing"
+)
+
+var platformEnvVars = []string{
+	PLATFORM_OVERRIDE_ENV, "CE_APP", "CE_JOB", "CE_SUBDOMAIN", "__OW_ACTION_NAME",
+	"__OW_API_HOST", "VCAP_APPLICATION", "KUBERNETES_SERVICE_HOST",
+}
+
+func clearPlatformEnv(t *testing.T) {
+	for _, name := range platformEnvVars {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	original := kubernetesServiceAccountPath
+	kubernetesServiceAccountPath = "/invalid-service-account-path"
+	t.Cleanup(func() { kubernetesServiceAccountPath = original })
+}
+
+func TestPlatformDetection(t *testing.T) {
+	cases := []struct {
+		env      string
+		value    string
+		platform string
+	}{
+		{"", "", PLATFORM_LOCAL},
+		{"VCAP_APPLICATION", vcap_application, PLATFORM_CLOUDFOUNDRY},
+		{"KUBERNETES_SERVICE_HOST", "10.0.0.1", PLATFORM_KUBERNETES},
+		{"CE_APP", "my-app", PLATFORM_CODE_ENGINE},
+		{"__OW_ACTION_NAME", "/ns/action", PLATFORM_CLOUD_FUNCTIONS},
+		{PLATFORM_OVERRIDE_ENV, "Kubernetes", PLATFORM_KUBERNETES},
+	}
+	for _, c := range cases {
+		clearPlatformEnv(t)
+		if c.env != "" {
+			os.Setenv(c.env, c.value)
+		}
+		if platform := Platform(); platform != c.platform {
+			t.Errorf("Got: \t%s\n Wanted: \t%s\n", platform, c.platform)
+		}
+	}
+}
+
+func TestCodeEngineWinsOverKubernetes(t *testing.T) {
+	clearPlatformEnv(t)
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	os.Setenv("CE_APP", "my-app")
+	if platform := Platform(); platform != PLATFORM_CODE_ENGINE {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", platform, PLATFORM_CODE_ENGINE)
+	}
+}
+
+func TestPlatformConditionalSearchPatterns(t *testing.T) {
+	t.Setenv("PLATFORM_K8S_VALUE", "k8s-value")
+	t.Setenv("PLATFORM_CF_VALUE", "cf-value")
+	t.Setenv("PLATFORM_DEFAULT_VALUE", "default-value")
+
+	cases := map[string]string{
+		"":                        "default-value",
+		"VCAP_APPLICATION":        "cf-value",
+		"CE_APP":                  "cf-value",
+		"KUBERNETES_SERVICE_HOST": "k8s-value",
+	}
+	for env, expected := range cases {
+		clearPlatformEnv(t)
+		if env != "" {
+			os.Setenv(env, "set")
+		}
+		delete(loadedMappings, "platform_var1")
+		Initialize("server/config/platform/mappings.json")
+		testString, _ := GetString("platform_var1")
+		if testString != expected {
+			t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, expected)
+		}
+	}
+}
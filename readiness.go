@@ -0,0 +1,100 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readinessTimeout bounds the resolution of missing mappings by ReadinessHandler, below the
+// one second Kubernetes waits for a probe by default
+var readinessTimeout = 800 * time.Millisecond
+
+type readinessReport struct {
+	Ready   bool     `json:"ready"`
+	Missing []string `json:"missing"`
+}
+
+// MissingRequired returns the mappings of the last Initialize marked "required": true that are not resolved.
+// Each missing mapping is resolved again first, so a mapping whose secret file or
+// environment variable appeared since Initialize no longer counts as missing.
+// Templates are retried after all other mappings.
+func MissingRequired() []string {
+	return MissingRequiredContext(context.Background())
+}
+
+// MissingRequiredContext is MissingRequired resolving the missing mappings within the
+// deadline of ctx. Mappings not resolved when ctx ends are reported as missing.
+func MissingRequiredContext(ctx context.Context) []string {
+	definitionsMutex.RLock()
+	required := make(map[string]mappingDefinition)
+	for name, definition := range mappingDefinitions {
+		if currentMappings[name] && definition.config.Get("required").Bool() {
+			required[name] = definition
+		}
+	}
+	definitionsMutex.RUnlock()
+
+	missing := []string{}
+	for _, templates := range []bool{false, true} {
+		for _, name := range sortedKeys(required) {
+			definition := required[name]
+			if definition.template != templates || mappingResolved(name) {
+				continue
+			}
+			if ctx.Err() != nil || !resolveDefinition(ctx, name, definition) {
+				missing = append(missing, name)
+			}
+		}
+	}
+	return missing
+}
+
+// HealthCheck returns an error listing the missing required mappings, or nil when all resolved
+func HealthCheck() error {
+	if missing := MissingRequired(); len(missing) > 0 {
+		return fmt.Errorf("required mappings not resolved: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Ready reports whether all required mappings resolved
+func Ready() bool {
+	return HealthCheck() == nil
+}
+
+// ReadinessHandler returns an http.Handler for Kubernetes readiness probes. It responds
+// 200 when all required mappings resolved and 503 with the missing ones otherwise.
+// Missing mappings are resolved again within the request context, for at most 800ms.
+func ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		missing := MissingRequiredContext(ctx)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if len(missing) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(readinessReport{Ready: len(missing) == 0, Missing: missing})
+	})
+}
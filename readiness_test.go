@@ -0,0 +1,105 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadinessFlipsWhenSecretAppears(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	os.Unsetenv("READINESS_SECRET")
+	mappingsFile, _ := filepath.Abs("server/config/readiness/mappings.json")
+	dir := t.TempDir()
+	t.Chdir(dir)
+	Initialize(mappingsFile)
+
+	if Ready() {
+		t.Errorf("Expected not ready while the secret is missing\n")
+	}
+	recorder := httptest.NewRecorder()
+	ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+	missing := gjson.Get(recorder.Body.String(), "missing").Array()
+	if recorder.Code != 503 || len(missing) != 2 || missing[0].String() != "ready_var1" || missing[1].String() != "ready_var3" {
+		t.Errorf("Unexpected response %d: %s\n", recorder.Code, recorder.Body.String())
+	}
+
+	// the mounted secret appears without calling Initialize again
+	os.MkdirAll(dir+"/test/cases/readiness", 0755)
+	ioutil.WriteFile(dir+"/test/cases/readiness/secret.txt", []byte("mounted-secret"), 0644)
+
+	if err := HealthCheck(); err != nil {
+		t.Errorf("Expected ready once the secret appeared: %v\n", err)
+	}
+	recorder = httptest.NewRecorder()
+	ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+	if recorder.Code != 200 || !gjson.Get(recorder.Body.String(), "ready").Bool() {
+		t.Errorf("Unexpected response %d: %s\n", recorder.Code, recorder.Body.String())
+	}
+	testString, _ := GetString("ready_var3")
+	if testString != "secret=mounted-secret" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "secret=mounted-secret")
+	}
+}
+
+func TestReadinessAfterReload(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	os.Unsetenv("READINESS_SECRET")
+	Initialize("server/config/readiness/mappings.json")
+	if Ready() {
+		t.Errorf("Expected not ready while the secret is missing\n")
+	}
+
+	// the required mappings of the previous mappings file no longer count
+	Initialize("server/config/template/mappings.json")
+	if missing := MissingRequired(); len(missing) != 0 {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", missing, []string{})
+	}
+}
+
+func TestReadinessDeadline(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	server := newHTTPStandIn(t)
+	readinessTimeout = 100 * time.Millisecond
+	defer func() { readinessTimeout = 800 * time.Millisecond }()
+	mappingsFile := t.TempDir() + "/mappings.json"
+	ioutil.WriteFile(mappingsFile, []byte(`{"ready_slow": {"required": true, "searchPatterns": ["`+server.URL+`/slow"]}}`), 0644)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	InitializeContext(ctx, mappingsFile)
+
+	// a source that does not answer does not hold the probe past its timeout
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/ready", nil))
+	if recorder.Code != 503 || time.Since(start) > 2*time.Second {
+		t.Errorf("Unexpected response %d after %s: %s\n", recorder.Code, time.Since(start), recorder.Body.String())
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if missing := MissingRequiredContext(canceled); len(missing) != 1 || missing[0] != "ready_slow" {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", missing, []string{"ready_slow"})
+	}
+}
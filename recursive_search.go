@@ -0,0 +1,120 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RECURSIVE_SEARCH_STRICT, set as "recursiveSearch" in a mappings file, makes keys that
+// match more than once in a recursive search an error rather than picking one
+const RECURSIVE_SEARCH_STRICT = "strict"
+
+var recursiveSearchMutex sync.RWMutex
+var recursiveSearchStrictMode bool
+
+// AmbiguousKeyError reports a key found at more than one path by a strict recursive search
+type AmbiguousKeyError struct {
+	Key   string
+	Paths []string
+}
+
+func (e *AmbiguousKeyError) Error() string {
+	return fmt.Sprintf("key %s is ambiguous, it is found at %s", e.Key, strings.Join(e.Paths, ", "))
+}
+
+func setRecursiveSearchStrict(strict bool) {
+	recursiveSearchMutex.Lock()
+	defer recursiveSearchMutex.Unlock()
+	recursiveSearchStrictMode = strict
+}
+
+func recursiveSearchStrict() bool {
+	recursiveSearchMutex.RLock()
+	defer recursiveSearchMutex.RUnlock()
+	return recursiveSearchStrictMode
+}
+
+// keyMatch is a value found by a recursive search and the path of the object it was found in
+type keyMatch struct {
+	path  []string
+	value gjson.Result
+}
+
+func (m keyMatch) pathString(search string) string {
+	parts := make([]string, 0, len(m.path)+1)
+	for _, part := range m.path {
+		parts = append(parts, escapeGJSONPath(part))
+	}
+	return strings.Join(append(parts, search), ".")
+}
+
+// findKey collects every match of search in current and in all nested objects and arrays
+func findKey(current gjson.Result, search string, path []string, matches []keyMatch) []keyMatch {
+	if result := current.Get(search); result.Exists() {
+		matches = append(matches, keyMatch{path: path, value: result})
+	}
+	if current.IsObject() || current.IsArray() {
+		index := 0
+		current.ForEach(func(key, value gjson.Result) bool {
+			name := key.String()
+			if current.IsArray() {
+				name = strconv.Itoa(index)
+				index++
+			}
+			matches = findKey(value, search, append(append([]string{}, path...), name), matches)
+			return true
+		})
+	}
+	return matches
+}
+
+// sortKeyMatches orders matches by depth, then by path, comparing numeric parts such as
+// array indices as numbers
+func sortKeyMatches(matches []keyMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		left, right := matches[i].path, matches[j].path
+		if len(left) != len(right) {
+			return len(left) < len(right)
+		}
+		for k := range left {
+			if left[k] == right[k] {
+				continue
+			}
+			leftIndex, leftErr := strconv.Atoi(left[k])
+			rightIndex, rightErr := strconv.Atoi(right[k])
+			if leftErr == nil && rightErr == nil {
+				return leftIndex < rightIndex
+			}
+			return left[k] < right[k]
+		}
+		return false
+	})
+}
+
+func newAmbiguousKeyError(search string, matches []keyMatch) *AmbiguousKeyError {
+	paths := make([]string, len(matches))
+	for i, match := range matches {
+		paths[i] = match.pathString(search)
+	}
+	return &AmbiguousKeyError{Key: search, Paths: paths}
+}
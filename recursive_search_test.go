@@ -0,0 +1,86 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"github.com/tidwall/gjson"
+	"reflect"
+	"testing"
+)
+
+const recursiveCredentials = `{
+	"name": "recursive-service",
+	"credentials": {
+		"readers": [
+			{"apikey": "reader-2"},
+			{"apikey": "reader-10"}
+		],
+		"writer": {"apikey": "writer"},
+		"admin": {"apikey": "admin"}
+	}
+}`
+
+func TestDeepSearchOrdering(t *testing.T) {
+	setRecursiveSearchStrict(false)
+	for _, test := range []struct {
+		document, search, expected string
+	}{
+		{recursiveCredentials, "apikey", "admin"},
+		{recursiveCredentials, "writer.apikey", "writer"},
+		{recursiveCredentials, "readers.1.apikey", "reader-10"},
+		{`{"nested": {"deeper": {"key": "deep"}}, "z": {"key": "shallow"}}`, "key", "shallow"},
+		{`{"list": [{"b": {"key": "second"}}, {"key": "first"}]}`, "key", "first"},
+	} {
+		if value, ok := deepSearch(gjson.Parse(test.document), test.search); !ok || value != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.search, value, test.expected)
+		}
+	}
+}
+
+func TestAmbiguousKeyPaths(t *testing.T) {
+	matches := findKey(gjson.Parse(recursiveCredentials), "apikey", nil, nil)
+	sortKeyMatches(matches)
+	err := newAmbiguousKeyError("apikey", matches)
+	expected := []string{
+		"credentials.admin.apikey",
+		"credentials.writer.apikey",
+		"credentials.readers.0.apikey",
+		"credentials.readers.1.apikey",
+	}
+	if !reflect.DeepEqual(err.Paths, expected) {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", err.Paths, expected)
+	}
+	if err.Error() != "key apikey is ambiguous, it is found at credentials.admin.apikey, credentials.writer.apikey, credentials.readers.0.apikey, credentials.readers.1.apikey" {
+		t.Errorf("Unexpected error message %s\n", err.Error())
+	}
+}
+
+func TestStrictRecursiveSearch(t *testing.T) {
+	t.Setenv("VCAP_SERVICES", `{"user-provided": [`+recursiveCredentials+`]}`)
+	t.Setenv("RECURSIVE_FALLBACK", "fallback")
+	Initialize("server/config/recursive_search/mappings.json")
+	defer setRecursiveSearchStrict(false)
+
+	testString, _ := GetString("strict_unique")
+	if testString != "writer" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "writer")
+	}
+	testString, _ = GetString("strict_ambiguous")
+	if testString != "fallback" {
+		t.Errorf("Ambiguous keys should not resolve in strict mode, got: %s\n", testString)
+	}
+}
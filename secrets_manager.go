@@ -0,0 +1,293 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const PREFIX_PATTERN_SECRETS_MANAGER = "secretsmanager"
+
+// SECRETS_MANAGER_URL_ENV is the endpoint of the Secrets Manager instance, e.g.
+// https://<instance-id>.us-south.secrets-manager.appdomain.cloud
+const SECRETS_MANAGER_URL_ENV = "SECRETS_MANAGER_URL"
+
+// The IAM apikey used with Secrets Manager, either itself or as the name of a mapping that
+// holds it. SECRETS_MANAGER_IAM_URL_ENV overrides the IAM token endpoint.
+const SECRETS_MANAGER_APIKEY_ENV = "SECRETS_MANAGER_APIKEY"
+const SECRETS_MANAGER_APIKEY_MAPPING_ENV = "SECRETS_MANAGER_APIKEY_MAPPING"
+const SECRETS_MANAGER_IAM_URL_ENV = "SECRETS_MANAGER_IAM_URL"
+
+// Secret types whose values are returned as a document rather than the full secret
+const SECRET_TYPE_ARBITRARY = "arbitrary"
+const SECRET_TYPE_USERNAME_PASSWORD = "username_password"
+const SECRET_TYPE_IAM_CREDENTIALS = "iam_credentials"
+const SECRET_TYPE_KV = "kv"
+
+var secretIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var secretsManagerMutex sync.Mutex
+var secretsManagerSecrets = make(map[string]string)
+var secretsManagerIDs = make(map[string]string)
+var secretsManagerTokens = make(map[string]*IAMTokenProvider)
+var secretsManagerGroup singleflight.Group
+var secretsManagerClient = &http.Client{Timeout: 30 * time.Second}
+
+// processSecretsManagerSearchPattern handles secretsmanager:<secret-id-or-name>[:$.JSONPath].
+// The selector applies to the value of the secret, see secretValue.
+func processSecretsManagerSearchPattern(ctx context.Context, patternComponents []string) (string, bool) {
+	if len(patternComponents) < 2 || patternComponents[1] == "" {
+		return "", false
+	}
+	value, err := readSecretsManagerSecret(ctx, patternComponents[1])
+	if err != nil {
+		log.Errorln("Failed to read Secrets Manager secret", patternComponents[1], err)
+		return "", false
+	}
+	if len(patternComponents) == 3 {
+		return processSelector(value, patternComponents[2])
+	}
+	return value, true
+}
+
+// resetSecretsManager drops the cached secrets, so that a new load reads them again. Name
+// lookups, which are dropped when their secret is gone, and IAM tokens are kept.
+func resetSecretsManager() {
+	secretsManagerMutex.Lock()
+	defer secretsManagerMutex.Unlock()
+	secretsManagerSecrets = make(map[string]string)
+}
+
+// secretsManagerInstance is the Secrets Manager instance configured by the environment
+type secretsManagerInstance struct {
+	url   string
+	token *IAMTokenProvider
+}
+
+func loadSecretsManagerInstance() (secretsManagerInstance, error) {
+	instance := secretsManagerInstance{url: strings.TrimRight(os.Getenv(SECRETS_MANAGER_URL_ENV), "/")}
+	if instance.url == "" {
+		return instance, fmt.Errorf("%s is not set", SECRETS_MANAGER_URL_ENV)
+	}
+	iamURL := os.Getenv(SECRETS_MANAGER_IAM_URL_ENV)
+	// an empty SECRETS_MANAGER_APIKEY is treated as unset, so that the mapping is used
+	apikey := os.Getenv(SECRETS_MANAGER_APIKEY_ENV)
+	apikeyFound := apikey != ""
+	mapping := os.Getenv(SECRETS_MANAGER_APIKEY_MAPPING_ENV)
+	if !apikeyFound && mapping == "" {
+		return instance, fmt.Errorf("no apikey configured, set %s or %s", SECRETS_MANAGER_APIKEY_ENV, SECRETS_MANAGER_APIKEY_MAPPING_ENV)
+	}
+
+	key := iamURL + "|mapping|" + mapping
+	if apikeyFound {
+		key = iamURL + "|apikey|" + apikey
+	}
+	secretsManagerMutex.Lock()
+	defer secretsManagerMutex.Unlock()
+	if instance.token = secretsManagerTokens[key]; instance.token == nil {
+		if apikeyFound {
+			instance.token = NewIAMTokenProvider(SECRETS_MANAGER_APIKEY_ENV, &IAMOptions{TokenURL: iamURL})
+			instance.token.lookupApikey = func(context.Context) (string, bool) {
+				return apikey, true
+			}
+		} else {
+			instance.token = NewIAMTokenProvider(mapping, &IAMOptions{TokenURL: iamURL})
+		}
+		secretsManagerTokens[key] = instance.token
+	}
+	return instance, nil
+}
+
+// readSecretsManagerSecret returns the value of a secret given by ID or by name
+func readSecretsManagerSecret(ctx context.Context, secret string) (string, error) {
+	instance, err := loadSecretsManagerInstance()
+	if err != nil {
+		return "", err
+	}
+	if secretIDPattern.MatchString(secret) {
+		return instance.secret(ctx, secret)
+	}
+	id, err := instance.secretID(ctx, secret)
+	if err != nil {
+		return "", err
+	}
+	value, err := instance.secret(ctx, id)
+	if status, ok := err.(secretsManagerStatusError); ok && status.code == http.StatusNotFound {
+		// the secret was replaced by one with the same name since the name was looked up
+		instance.forgetSecretID(secret)
+		if id, err = instance.secretID(ctx, secret); err != nil {
+			return "", err
+		}
+		value, err = instance.secret(ctx, id)
+	}
+	return value, err
+}
+
+// secret returns the value of the secret with an ID, from the cache when it was read since
+// the last Initialize
+func (i secretsManagerInstance) secret(ctx context.Context, id string) (string, error) {
+	key := i.url + "|" + id
+	secretsManagerMutex.Lock()
+	value, ok := secretsManagerSecrets[key]
+	secretsManagerMutex.Unlock()
+	if ok {
+		return value, nil
+	}
+	result, err, _ := secretsManagerGroup.Do("secret|"+key, func() (interface{}, error) {
+		response, err := i.request(ctx, "secrets/"+url.PathEscape(id))
+		if err != nil {
+			return "", err
+		}
+		value, err := secretValue(response)
+		if err != nil {
+			return "", err
+		}
+		secretsManagerMutex.Lock()
+		secretsManagerSecrets[key] = value
+		secretsManagerMutex.Unlock()
+		return value, nil
+	})
+	return result.(string), err
+}
+
+// secretID looks up the ID of the secret with a name. Names must be unique in the instance.
+// IDs are kept across loads until reading the secret fails with 404.
+func (i secretsManagerInstance) secretID(ctx context.Context, name string) (string, error) {
+	key := i.url + "|" + name
+	secretsManagerMutex.Lock()
+	id, ok := secretsManagerIDs[key]
+	secretsManagerMutex.Unlock()
+	if ok {
+		return id, nil
+	}
+	result, err, _ := secretsManagerGroup.Do("name|"+key, func() (interface{}, error) {
+		response, err := i.request(ctx, "secrets?limit=1000&search="+url.QueryEscape(name))
+		if err != nil {
+			return "", err
+		}
+		ids := []string{}
+		response.Get("secrets").ForEach(func(_, metadata gjson.Result) bool {
+			if metadata.Get("name").String() == name {
+				ids = append(ids, metadata.Get("id").String())
+			}
+			return true
+		})
+		if len(ids) == 0 {
+			return "", fmt.Errorf("no secret named %s", name)
+		} else if len(ids) > 1 {
+			return "", fmt.Errorf("secret name %s is ambiguous, it is used by %s", name, strings.Join(ids, ", "))
+		}
+		secretsManagerMutex.Lock()
+		secretsManagerIDs[key] = ids[0]
+		secretsManagerMutex.Unlock()
+		return ids[0], nil
+	})
+	return result.(string), err
+}
+
+func (i secretsManagerInstance) forgetSecretID(name string) {
+	secretsManagerMutex.Lock()
+	defer secretsManagerMutex.Unlock()
+	delete(secretsManagerIDs, i.url+"|"+name)
+}
+
+// secretsManagerStatusError is a response of the Secrets Manager API with a status other than 200
+type secretsManagerStatusError struct {
+	code    int
+	message string
+}
+
+func (e secretsManagerStatusError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.code, e.message)
+}
+
+// secretValue returns the payload of an arbitrary secret, the username and password of a
+// username_password secret, the apikey of an iam_credentials secret and the data of a kv
+// secret. Other secret types, such as certificates, are returned as they are.
+func secretValue(secret gjson.Result) (string, error) {
+	var value interface{}
+	switch secret.Get("secret_type").String() {
+	case SECRET_TYPE_ARBITRARY:
+		if !secret.Get("payload").Exists() {
+			return "", fmt.Errorf("secret contains no payload")
+		}
+		return secret.Get("payload").String(), nil
+	case SECRET_TYPE_USERNAME_PASSWORD:
+		value = map[string]string{"username": secret.Get("username").String(), "password": secret.Get("password").String()}
+	case SECRET_TYPE_IAM_CREDENTIALS:
+		if !secret.Get("api_key").Exists() {
+			return "", fmt.Errorf("secret contains no api_key")
+		}
+		value = map[string]string{"apikey": secret.Get("api_key").String(), "service_id": secret.Get("service_id").String()}
+	case SECRET_TYPE_KV:
+		if !secret.Get("data").IsObject() {
+			return "", fmt.Errorf("secret contains no data")
+		}
+		return secret.Get("data").Raw, nil
+	default:
+		return secret.Raw, nil
+	}
+	bytes, err := json.Marshal(value)
+	return string(bytes), err
+}
+
+// request sends an authorized GET request to the Secrets Manager v2 API
+func (i secretsManagerInstance) request(ctx context.Context, path string) (gjson.Result, error) {
+	token, err := i.token.TokenContext(ctx)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, i.url+"/api/v2/"+path, nil)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := secretsManagerClient.Do(request)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	defer response.Body.Close()
+	body, err := readResponseBody(response.Body)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	if response.StatusCode != http.StatusOK {
+		message := gjson.GetBytes(body, "errors.0.message").String()
+		if message == "" {
+			message = http.StatusText(response.StatusCode)
+		}
+		return gjson.Result{}, secretsManagerStatusError{response.StatusCode, message}
+	}
+	if !gjson.ValidBytes(body) {
+		return gjson.Result{}, fmt.Errorf("response is not valid JSON")
+	}
+	return gjson.ParseBytes(body), nil
+}
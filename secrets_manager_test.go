@@ -0,0 +1,246 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const secretArbitraryID = "0b5571f7-21e6-42b7-91c5-3f5ac9793a46"
+const secretUsernamePasswordID = "4a6b3e2c-8d1f-4c5a-9e7b-2f1d3c4b5a69"
+const secretIAMCredentialsID = "7c8d9e0f-1a2b-4c3d-8e5f-6a7b8c9d0e1f"
+const secretKVID = "9f8e7d6c-5b4a-4392-8170-6f5e4d3c2b1a"
+const secretCertificateID = "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+
+// secretsManagerStandIn serves the parts of the Secrets Manager v2 API the secretsmanager:
+// prefix uses, accepting the bearer tokens of newIAMServer
+type secretsManagerStandIn struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	secrets  map[string]map[string]interface{}
+	requests map[string]int
+}
+
+func newSecretsManagerStandIn(t *testing.T, apikey string) (*secretsManagerStandIn, *int32) {
+	var tokenRequests int32
+	iam := newIAMServer(t, apikey, &tokenRequests)
+	t.Cleanup(iam.Close)
+
+	sm := &secretsManagerStandIn{
+		secrets: map[string]map[string]interface{}{
+			secretArbitraryID:                      {"name": "api-config", "secret_type": "arbitrary", "payload": `{"endpoint":"https://api","retries":3}`},
+			secretUsernamePasswordID:               {"name": "db-credentials", "secret_type": "username_password", "username": "admin", "password": "db-password"},
+			secretIAMCredentialsID:                 {"name": "service-apikey", "secret_type": "iam_credentials", "api_key": "generated-apikey", "service_id": "ServiceId-1"},
+			secretKVID:                             {"name": "feature-flags", "secret_type": "kv", "data": map[string]interface{}{"beta": true}},
+			secretCertificateID:                    {"name": "tls-cert", "secret_type": "imported_cert", "certificate": "-----BEGIN CERTIFICATE-----"},
+			"5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b1a": {"name": "duplicate", "secret_type": "arbitrary", "payload": "one"},
+			"6e5d4c3b-2a1f-4e0d-9c8b-7a6f5e4d3c2b": {"name": "duplicate", "secret_type": "arbitrary", "payload": "two"},
+		},
+		requests: map[string]int{},
+	}
+	sm.Server = httptest.NewServer(http.HandlerFunc(sm.serve))
+	t.Cleanup(sm.Close)
+	t.Setenv(SECRETS_MANAGER_URL_ENV, sm.URL)
+	t.Setenv(SECRETS_MANAGER_IAM_URL_ENV, iam.URL)
+	t.Setenv(SECRETS_MANAGER_APIKEY_MAPPING_ENV, "")
+	return sm, &tokenRequests
+}
+
+func (s *secretsManagerStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors":[{"code":"unauthorized","message":"Unauthorized"}],"status_code":401}`))
+		return
+	}
+	s.requests[r.URL.Path]++
+	if r.URL.Path == "/api/v2/secrets" {
+		metadata := []map[string]interface{}{}
+		for id, secret := range s.secrets {
+			if strings.Contains(secret["name"].(string), r.URL.Query().Get("search")) {
+				metadata = append(metadata, map[string]interface{}{"id": id, "name": secret["name"], "secret_type": secret["secret_type"]})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"secrets": metadata, "total_count": len(metadata)})
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v2/secrets/")
+	secret, ok := s.secrets[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[{"code":"not_found","message":"Secret not found"}],"status_code":404}`))
+		return
+	}
+	response := map[string]interface{}{"id": id}
+	for key, value := range secret {
+		response[key] = value
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// unsetenv unsets an environment variable for the duration of the test
+func unsetenv(t *testing.T, name string) {
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func (s *secretsManagerStandIn) requestCount(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+func TestSecretsManagerSecretTypes(t *testing.T) {
+	newSecretsManagerStandIn(t, "sm-apikey")
+	t.Setenv(SECRETS_MANAGER_APIKEY_ENV, "sm-apikey")
+	resetSecretsManager()
+	for _, test := range []struct {
+		pattern, expected string
+	}{
+		{"secretsmanager:" + secretArbitraryID, `{"endpoint":"https://api","retries":3}`},
+		{"secretsmanager:" + secretArbitraryID + ":$.endpoint", "https://api"},
+		{"secretsmanager:api-config:..retries", "3"},
+		{"secretsmanager:db-credentials", `{"password":"db-password","username":"admin"}`},
+		{"secretsmanager:db-credentials:$.username", "admin"},
+		{"secretsmanager:service-apikey:$.apikey", "generated-apikey"},
+		{"secretsmanager:feature-flags:$.beta", "true"},
+		{"secretsmanager:tls-cert:$.certificate", "-----BEGIN CERTIFICATE-----"},
+	} {
+		if value, ok := processSearchPattern(context.Background(), "secretsmanager", test.pattern, patternOptions{}); !ok || value != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.pattern, value, test.expected)
+		}
+	}
+	for _, pattern := range []string{"secretsmanager:missing-secret", "secretsmanager:duplicate", "secretsmanager:2b1a0f9e-8d7c-4b6a-9584-736251403f2e", "secretsmanager:"} {
+		if _, ok := processSearchPattern(context.Background(), "secretsmanager", pattern, patternOptions{}); ok {
+			t.Errorf("Expected %s not to resolve\n", pattern)
+		}
+	}
+	if _, err := readSecretsManagerSecret(context.Background(), "duplicate"); err == nil || !strings.HasPrefix(err.Error(), "secret name duplicate is ambiguous") {
+		t.Errorf("Expected an ambiguous name error, got: %v\n", err)
+	}
+}
+
+func TestSecretsManagerCaching(t *testing.T) {
+	sm, tokenRequests := newSecretsManagerStandIn(t, "sm-cache-apikey")
+	t.Setenv(SECRETS_MANAGER_APIKEY_ENV, "sm-cache-apikey")
+	resetSecretsManager()
+	for i := 0; i < 3; i++ {
+		readSecretsManagerSecret(context.Background(), "db-credentials")
+	}
+	if sm.requestCount("/api/v2/secrets") != 1 || sm.requestCount("/api/v2/secrets/"+secretUsernamePasswordID) != 1 {
+		t.Errorf("Expected the secret to be looked up and read once, got %d and %d requests\n",
+			sm.requestCount("/api/v2/secrets"), sm.requestCount("/api/v2/secrets/"+secretUsernamePasswordID))
+	}
+
+	// a new load reads the secret again, reusing the name lookup and the IAM token
+	resetSecretsManager()
+	readSecretsManagerSecret(context.Background(), "db-credentials")
+	if sm.requestCount("/api/v2/secrets") != 1 || sm.requestCount("/api/v2/secrets/"+secretUsernamePasswordID) != 2 {
+		t.Errorf("Expected only the secret to be read again, got %d and %d requests\n",
+			sm.requestCount("/api/v2/secrets"), sm.requestCount("/api/v2/secrets/"+secretUsernamePasswordID))
+	}
+	if *tokenRequests != 1 {
+		t.Errorf("Expected a single token exchange, got %d\n", *tokenRequests)
+	}
+}
+
+func TestSecretsManagerSecretReplaced(t *testing.T) {
+	sm, _ := newSecretsManagerStandIn(t, "sm-replaced-apikey")
+	t.Setenv(SECRETS_MANAGER_APIKEY_ENV, "sm-replaced-apikey")
+	resetSecretsManager()
+	readSecretsManagerSecret(context.Background(), "api-config")
+
+	// the secret is deleted and created again under the same name with a new ID
+	const replacedID = "3e2d1c0b-9a8f-4e7d-8c6b-5a4f3e2d1c0b"
+	sm.mutex.Lock()
+	sm.secrets[replacedID] = sm.secrets[secretArbitraryID]
+	sm.secrets[replacedID]["payload"] = "replaced"
+	delete(sm.secrets, secretArbitraryID)
+	sm.mutex.Unlock()
+	resetSecretsManager()
+	if value, err := readSecretsManagerSecret(context.Background(), "api-config"); err != nil || value != "replaced" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "replaced")
+	}
+	if sm.requestCount("/api/v2/secrets") != 2 {
+		t.Errorf("Expected the name to be looked up again, got %d lookups\n", sm.requestCount("/api/v2/secrets"))
+	}
+}
+
+func TestSecretsManagerResponseLimit(t *testing.T) {
+	sm, _ := newSecretsManagerStandIn(t, "sm-limit-apikey")
+	t.Setenv(SECRETS_MANAGER_APIKEY_ENV, "sm-limit-apikey")
+	resetSecretsManager()
+	sm.mutex.Lock()
+	sm.secrets[secretArbitraryID]["payload"] = strings.Repeat("x", HTTP_MAX_RESPONSE_SIZE)
+	sm.mutex.Unlock()
+	if _, err := readSecretsManagerSecret(context.Background(), secretArbitraryID); err != errResponseTooLarge {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", err, errResponseTooLarge)
+	}
+}
+
+func TestSecretsManagerMappings(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	newSecretsManagerStandIn(t, "sm-mapping-apikey")
+	t.Setenv("SECRETS_MANAGER_TEST_APIKEY", "sm-mapping-apikey")
+	t.Setenv("SECRETS_MANAGER_FALLBACK", "fallback")
+	t.Setenv(SECRETS_MANAGER_APIKEY_MAPPING_ENV, "secrets_manager_apikey")
+	unsetenv(t, SECRETS_MANAGER_APIKEY_ENV)
+
+	Initialize("server/config/secrets_manager/mappings.json")
+	for name, expected := range map[string]string{"secrets_manager_var1": "db-password", "secrets_manager_var2": "fallback"} {
+		if value, _ := GetString(name); value != expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", name, value, expected)
+		}
+	}
+
+	// an empty SECRETS_MANAGER_APIKEY does not hide the mapping
+	t.Setenv(SECRETS_MANAGER_APIKEY_ENV, "")
+	Initialize("server/config/secrets_manager/mappings.json")
+	if value, _ := GetString("secrets_manager_var1"); value != "db-password" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "db-password")
+	}
+}
+
+func TestSecretsManagerErrors(t *testing.T) {
+	newSecretsManagerStandIn(t, "sm-apikey")
+	t.Setenv(SECRETS_MANAGER_APIKEY_ENV, "wrong-apikey")
+	resetSecretsManager()
+	if _, err := readSecretsManagerSecret(context.Background(), secretArbitraryID); err == nil || !strings.Contains(err.Error(), "Provided API key could not be found") {
+		t.Errorf("Expected the IAM error, got: %v\n", err)
+	}
+	unsetenv(t, SECRETS_MANAGER_APIKEY_ENV)
+	if _, err := readSecretsManagerSecret(context.Background(), secretArbitraryID); err == nil || !strings.HasPrefix(err.Error(), "no apikey configured") {
+		t.Errorf("Expected a configuration error, got: %v\n", err)
+	}
+	t.Setenv(SECRETS_MANAGER_APIKEY_ENV, "")
+	if _, err := readSecretsManagerSecret(context.Background(), secretArbitraryID); err == nil || !strings.HasPrefix(err.Error(), "no apikey configured") {
+		t.Errorf("Expected a configuration error for an empty apikey, got: %v\n", err)
+	}
+	t.Setenv(SECRETS_MANAGER_URL_ENV, "")
+	if _, err := readSecretsManagerSecret(context.Background(), secretArbitraryID); err == nil || err.Error() != "SECRETS_MANAGER_URL is not set" {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "SECRETS_MANAGER_URL is not set")
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+const selectorVcapServices = `{
+	"user-provided": [
+		{
+			"name": "selector-service",
+			"credentials": {
+				"reader": null,
+				"writer": {
+					"apikey": "writer-apikey",
+					"port": 443
+				}
+			}
+		}
+	]
+}`
+
+func TestSelectors(t *testing.T) {
+	t.Setenv("VCAP_SERVICES", selectorVcapServices)
+	t.Setenv("SELECTOR_JSON", `{"nested": {"apikey": "env-apikey"}}`)
+	t.Setenv("SELECTOR_FALLBACK", "fallback")
+	Initialize("server/config/selector/mappings.json")
+
+	// objects found by name or by a recursive search are returned as written in VCAP_SERVICES,
+	// JSONPath results are marshalled
+	credentials := gjson.Get(selectorVcapServices, "user-provided.0.credentials")
+	for name, expected := range map[string]string{
+		"selector_jsonpath":     "writer-apikey",
+		"selector_recursive":    "writer-apikey",
+		"selector_dotted":       "writer-apikey",
+		"selector_object":       credentials.Get("writer").Raw,
+		"selector_null":         "fallback",
+		"selector_env":          "env-apikey",
+		"selector_cloudfoundry": credentials.Get("writer").Raw,
+		"selector_credentials":  credentials.Raw,
+	} {
+		testString, _ := GetString(name)
+		if testString != expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", name, testString, expected)
+		}
+	}
+}
+
+func TestSelectorsMatchAcrossPrefixes(t *testing.T) {
+	document := `{"credentials": {"writer": {"apikey": "writer-apikey", "port": 443, "tags": ["a"]}}}`
+	for _, key := range []string{"apikey", "port", "tags"} {
+		jsonPath, _ := processSelector(document, "$.credentials.writer."+key)
+		recursive, _ := processSelector(document, ".."+key)
+		if jsonPath != recursive {
+			t.Errorf("%s JSONPath: \t%s\n Recursive search: \t%s\n", key, jsonPath, recursive)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CredentialsValidator is implemented by credential structs that check their mandatory fields
+type CredentialsValidator interface {
+	Validate() error
+}
+
+// CloudantCredentials are the service credentials of a Cloudant instance
+type CloudantCredentials struct {
+	URL      string `json:"url"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	APIKey   string `json:"apikey"`
+}
+
+func (c CloudantCredentials) Validate() error {
+	if c.URL == "" && c.Host == "" {
+		return missingFields("url")
+	}
+	if c.APIKey == "" && (c.Username == "" || c.Password == "") {
+		return missingFields("apikey or username and password")
+	}
+	return nil
+}
+
+// CloudObjectStorageHMACCredentials are Cloud Object Storage credentials created with HMAC keys
+type CloudObjectStorageHMACCredentials struct {
+	HMACKeys struct {
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+	} `json:"cos_hmac_keys"`
+	Endpoints          string `json:"endpoints"`
+	ResourceInstanceID string `json:"resource_instance_id"`
+}
+
+func (c CloudObjectStorageHMACCredentials) Validate() error {
+	return requireFields(map[string]string{
+		"cos_hmac_keys.access_key_id":     c.HMACKeys.AccessKeyID,
+		"cos_hmac_keys.secret_access_key": c.HMACKeys.SecretAccessKey,
+	})
+}
+
+// CloudObjectStorageIAMCredentials are Cloud Object Storage credentials authenticating with IAM
+type CloudObjectStorageIAMCredentials struct {
+	APIKey             string `json:"apikey"`
+	Endpoints          string `json:"endpoints"`
+	IAMAPIKeyID        string `json:"iam_apikey_id"`
+	IAMServiceIDCRN    string `json:"iam_serviceid_crn"`
+	ResourceInstanceID string `json:"resource_instance_id"`
+}
+
+func (c CloudObjectStorageIAMCredentials) Validate() error {
+	return requireFields(map[string]string{
+		"apikey":               c.APIKey,
+		"resource_instance_id": c.ResourceInstanceID,
+	})
+}
+
+// WatsonCredentials are the credentials of a Watson service using either IAM or basic authentication.
+// IAMAPIKey is filled from "iam_apikey" as returned by GetCredentialsForService.
+type WatsonCredentials struct {
+	URL       string `json:"url"`
+	APIKey    string `json:"apikey"`
+	IAMAPIKey string `json:"iam_apikey"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// IAM reports whether the credentials use IAM rather than basic authentication
+func (c WatsonCredentials) IAM() bool {
+	return c.APIKey != "" || c.IAMAPIKey != ""
+}
+
+// Key returns the IAM apikey, whichever key it was stored under
+func (c WatsonCredentials) Key() string {
+	if c.IAMAPIKey != "" {
+		return c.IAMAPIKey
+	}
+	return c.APIKey
+}
+
+func (c WatsonCredentials) Validate() error {
+	if c.URL == "" {
+		return missingFields("url")
+	}
+	if !c.IAM() && (c.Username == "" || c.Password == "") {
+		return missingFields("apikey or username and password")
+	}
+	return nil
+}
+
+// EventStreamsCredentials are the service credentials of an Event Streams instance
+type EventStreamsCredentials struct {
+	APIKey           string   `json:"apikey"`
+	User             string   `json:"user"`
+	Password         string   `json:"password"`
+	KafkaBrokersSASL []string `json:"kafka_brokers_sasl"`
+	KafkaAdminURL    string   `json:"kafka_admin_url"`
+	KafkaHTTPURL     string   `json:"kafka_http_url"`
+}
+
+func (c EventStreamsCredentials) Validate() error {
+	if len(c.KafkaBrokersSASL) == 0 {
+		return missingFields("kafka_brokers_sasl")
+	}
+	if c.APIKey == "" && (c.User == "" || c.Password == "") {
+		return missingFields("apikey or user and password")
+	}
+	return nil
+}
+
+// AppIDCredentials are the service credentials of an App ID instance
+type AppIDCredentials struct {
+	APIKey               string `json:"apikey"`
+	AppIDServiceEndpoint string `json:"appidServiceEndpoint"`
+	ClientID             string `json:"clientId"`
+	DiscoveryEndpoint    string `json:"discoveryEndpoint"`
+	ManagementURL        string `json:"managementUrl"`
+	OAuthServerURL       string `json:"oauthServerUrl"`
+	ProfilesURL          string `json:"profilesUrl"`
+	Secret               string `json:"secret"`
+	TenantID             string `json:"tenantId"`
+	Version              int    `json:"version"`
+}
+
+func (c AppIDCredentials) Validate() error {
+	return requireFields(map[string]string{
+		"clientId":       c.ClientID,
+		"secret":         c.Secret,
+		"tenantId":       c.TenantID,
+		"oauthServerUrl": c.OAuthServerURL,
+	})
+}
+
+// GetServiceCredentials decodes the value of a mapping into a credentials struct such as
+// CloudantCredentials and validates it when the struct implements CredentialsValidator.
+func GetServiceCredentials[T any](name string) (T, error) {
+	return GetServiceCredentialsContext[T](context.Background(), name)
+}
+
+// GetServiceCredentialsContext is GetServiceCredentials resolving a lazy mapping within the
+// deadline of ctx
+func GetServiceCredentialsContext[T any](ctx context.Context, name string) (T, error) {
+	var credentials T
+	err := GetServiceCredentialsIntoContext(ctx, name, &credentials)
+	return credentials, err
+}
+
+// GetServiceCredentialsInto is GetServiceCredentials without type parameters, decoding the
+// mapping into out, a pointer to a credentials struct
+func GetServiceCredentialsInto(name string, out interface{}) error {
+	return GetServiceCredentialsIntoContext(context.Background(), name, out)
+}
+
+// GetServiceCredentialsIntoContext is GetServiceCredentialsInto resolving a lazy mapping
+// within the deadline of ctx
+func GetServiceCredentialsIntoContext(ctx context.Context, name string, out interface{}) error {
+	value, ok := GetStringContext(ctx, name)
+	if !ok && ctx.Err() != nil {
+		return &IncompleteError{Mappings: []string{name}, Err: ctx.Err()}
+	} else if !ok {
+		return fmt.Errorf("mapping %s does not exist", name)
+	}
+	if err := json.Unmarshal([]byte(value), out); err != nil {
+		return fmt.Errorf("mapping %s: %v", name, err)
+	}
+	// the pointer has the methods of value receivers too
+	if validator, ok := out.(CredentialsValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return fmt.Errorf("mapping %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func requireFields(fields map[string]string) error {
+	missing := []string{}
+	for _, name := range sortedKeys(fields) {
+		if fields[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return missingFields(missing...)
+	}
+	return nil
+}
+
+func missingFields(fields ...string) error {
+	return fmt.Errorf("missing mandatory credentials: %s", strings.Join(fields, ", "))
+}
@@ -0,0 +1,110 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCloudantCredentials(t *testing.T) {
+	loadedMappings["typed_var1"] = `{"url": "https://account.cloudant.com", "apikey": "cloudant-apikey", "port": 443}`
+	creds, err := GetServiceCredentials[CloudantCredentials]("typed_var1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.APIKey != "cloudant-apikey" || creds.Port != 443 {
+		t.Errorf("Unexpected credentials: %+v\n", creds)
+	}
+}
+
+func TestServiceCredentialsInto(t *testing.T) {
+	loadedMappings["typed_var10"] = `{"url": "https://account.cloudant.com", "apikey": "cloudant-apikey"}`
+	var creds CloudantCredentials
+	if err := GetServiceCredentialsInto("typed_var10", &creds); err != nil || creds.APIKey != "cloudant-apikey" {
+		t.Errorf("Unexpected credentials: %+v %v\n", creds, err)
+	}
+	loadedMappings["typed_var11"] = `{"url": "https://account.cloudant.com"}`
+	if err := GetServiceCredentialsInto("typed_var11", &CloudantCredentials{}); err == nil || !strings.Contains(err.Error(), "apikey") {
+		t.Errorf("Expected the credentials to be validated, got: %v\n", err)
+	}
+}
+
+func TestCloudObjectStorageCredentials(t *testing.T) {
+	loadedMappings["typed_var2"] = `{
+		"apikey": "cos-apikey",
+		"cos_hmac_keys": {"access_key_id": "access", "secret_access_key": "secret"},
+		"resource_instance_id": "crn:v1:bluemix:public:cloud-object-storage:global:a/1::"
+	}`
+	hmac, err := GetServiceCredentials[CloudObjectStorageHMACCredentials]("typed_var2")
+	if err != nil || hmac.HMACKeys.AccessKeyID != "access" || hmac.HMACKeys.SecretAccessKey != "secret" {
+		t.Errorf("Unexpected credentials: %+v %v\n", hmac, err)
+	}
+	iam, err := GetServiceCredentials[CloudObjectStorageIAMCredentials]("typed_var2")
+	if err != nil || iam.APIKey != "cos-apikey" {
+		t.Errorf("Unexpected credentials: %+v %v\n", iam, err)
+	}
+
+	loadedMappings["typed_var3"] = `{"apikey": "cos-apikey"}`
+	_, err = GetServiceCredentials[CloudObjectStorageHMACCredentials]("typed_var3")
+	if err == nil || !strings.Contains(err.Error(), "cos_hmac_keys.access_key_id, cos_hmac_keys.secret_access_key") {
+		t.Errorf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestWatsonCredentialsFromFilteredCredentials(t *testing.T) {
+	filtered := GetCredentialsForService("watson", "conversation", service_credentials)
+	filtered["url"] = "https://gateway.watsonplatform.net"
+	loadedMappings["typed_var4"] = filtered
+
+	creds, err := GetServiceCredentials[WatsonCredentials]("typed_var4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !creds.IAM() || creds.Key() != "apikey" {
+		t.Errorf("Unexpected credentials: %+v\n", creds)
+	}
+
+	loadedMappings["typed_var5"] = `{"url": "https://gateway.watsonplatform.net", "username": "user"}`
+	if _, err := GetServiceCredentials[WatsonCredentials]("typed_var5"); err == nil {
+		t.Errorf("Expected error for basic credentials without password\n")
+	}
+}
+
+func TestEventStreamsAndAppIDCredentials(t *testing.T) {
+	loadedMappings["typed_var6"] = `{"apikey": "es-apikey", "kafka_brokers_sasl": ["broker-0:9093", "broker-1:9093"]}`
+	es, err := GetServiceCredentials[EventStreamsCredentials]("typed_var6")
+	if err != nil || len(es.KafkaBrokersSASL) != 2 {
+		t.Errorf("Unexpected credentials: %+v %v\n", es, err)
+	}
+
+	loadedMappings["typed_var7"] = `{"clientId": "client", "secret": "secret", "tenantId": "tenant"}`
+	_, err = GetServiceCredentials[AppIDCredentials]("typed_var7")
+	if err == nil || !strings.Contains(err.Error(), "oauthServerUrl") {
+		t.Errorf("Unexpected error: %v\n", err)
+	}
+}
+
+func TestServiceCredentialsErrors(t *testing.T) {
+	if _, err := GetServiceCredentials[CloudantCredentials]("typed_missing"); err == nil {
+		t.Errorf("Expected error for missing mapping\n")
+	}
+	loadedMappings["typed_var8"] = "plain-text-string"
+	if _, err := GetServiceCredentials[CloudantCredentials]("typed_var8"); err == nil {
+		t.Errorf("Expected error for non JSON mapping\n")
+	}
+}
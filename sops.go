@@ -0,0 +1,543 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PREFIX_PATTERN_SOPS = "sops"
+
+// The same variables the sops binary reads its age identities from
+const SOPS_AGE_KEY_ENV = "SOPS_AGE_KEY"
+const SOPS_AGE_KEY_FILE_ENV = "SOPS_AGE_KEY_FILE"
+
+// sops leaves keys with this suffix unencrypted when the file names no other rule
+const SOPS_DEFAULT_UNENCRYPTED_SUFFIX = "_unencrypted"
+
+// sopsMACOnlyEncryptedInitialization starts the MAC of files with mac_only_encrypted set,
+// the same bytes sops uses
+var sopsMACOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	LastModified            string `yaml:"lastmodified"`
+	MAC                     string `yaml:"mac"`
+	UnencryptedSuffix       string `yaml:"unencrypted_suffix"`
+	EncryptedSuffix         string `yaml:"encrypted_suffix"`
+	UnencryptedRegex        string `yaml:"unencrypted_regex"`
+	EncryptedRegex          string `yaml:"encrypted_regex"`
+	UnencryptedCommentRegex string `yaml:"unencrypted_comment_regex"`
+	EncryptedCommentRegex   string `yaml:"encrypted_comment_regex"`
+	MACOnlyEncrypted        bool   `yaml:"mac_only_encrypted"`
+}
+
+// processSopsSearchPattern handles sops:/path/secrets.enc.yaml[:$.JSONPath]. The file is
+// decrypted with the local age identity, its integrity checked against the sops MAC and
+// the result converted to JSON before the JSONPath is applied.
+func processSopsSearchPattern(patternComponents []string) (string, bool) {
+	if len(patternComponents) < 2 {
+		return "", false
+	}
+	content, ok := readProjectFile(patternComponents[1])
+	if !ok {
+		return "", false
+	}
+	plaintext, err := decryptSops(content)
+	if err != nil {
+		log.Errorln("Failed to decrypt sops file", patternComponents[1], err)
+		return "", false
+	}
+	if len(patternComponents) == 3 {
+		return processSelector(string(plaintext), patternComponents[2])
+	}
+	return string(plaintext), true
+}
+
+// decryptSops decrypts a sops encrypted YAML or JSON document and returns it as JSON
+func decryptSops(content []byte) ([]byte, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	var document yaml.Node
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(&yaml.Node{}); err != io.EOF {
+		return nil, fmt.Errorf("sops files with several documents are not supported")
+	}
+	items, err := sopsTreeBranch(&document, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// the metadata is taken out of the tree, the comments around it stay
+	var metadata sopsMetadata
+	found := false
+	for i, item := range items {
+		if item.key == "sops" {
+			if err := item.node.Decode(&metadata); err != nil {
+				return nil, err
+			}
+			items = append(items[:i], items[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no sops metadata found")
+	}
+	if metadata.UnencryptedSuffix == "" && metadata.EncryptedSuffix == "" && metadata.UnencryptedRegex == "" &&
+		metadata.EncryptedRegex == "" && metadata.UnencryptedCommentRegex == "" && metadata.EncryptedCommentRegex == "" {
+		metadata.UnencryptedSuffix = SOPS_DEFAULT_UNENCRYPTED_SUFFIX
+	}
+
+	key, err := sopsDataKey(metadata)
+	if err != nil {
+		return nil, err
+	}
+	decrypter := &sopsDecrypter{key: key, metadata: metadata, hash: sha512.New()}
+	if metadata.MACOnlyEncrypted {
+		decrypter.hash.Write(sopsMACOnlyEncryptedInitialization)
+	}
+	tree, err := decrypter.branch(items, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := decrypter.verifyMAC(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+func sopsDataKey(metadata sopsMetadata) ([]byte, error) {
+	if len(metadata.Age) == 0 {
+		return nil, fmt.Errorf("only age encrypted sops files are supported")
+	}
+	identities, err := sopsAgeIdentities()
+	if err != nil {
+		return nil, err
+	}
+	for _, stanza := range metadata.Age {
+		reader, err := age.Decrypt(armor.NewReader(strings.NewReader(stanza.Enc)), identities...)
+		if err != nil {
+			continue
+		}
+		return ioutil.ReadAll(reader)
+	}
+	return nil, fmt.Errorf("no age identity matches the sops recipients")
+}
+
+// sopsAgeIdentities reads identities the way sops does: SOPS_AGE_KEY, SOPS_AGE_KEY_FILE,
+// then the keys.txt file in the user configuration directory
+func sopsAgeIdentities() ([]age.Identity, error) {
+	key, err := readKey(SOPS_AGE_KEY_ENV, SOPS_AGE_KEY_FILE_ENV)
+	if err != nil {
+		configDir, dirErr := os.UserConfigDir()
+		if dirErr != nil {
+			return nil, err
+		}
+		if key, dirErr = ioutil.ReadFile(filepath.Join(configDir, "sops", "age", "keys.txt")); dirErr != nil {
+			return nil, err
+		}
+	}
+	return age.ParseIdentities(bytes.NewReader(key))
+}
+
+// sopsComment is a comment line of a document. sops encrypts comments like values, but
+// leaves them out of the MAC and of the decrypted document.
+type sopsComment string
+
+// sopsItem is an entry of a mapping, or a comment between its entries when key is a sopsComment
+type sopsItem struct {
+	key   interface{}
+	value interface{}
+	node  *yaml.Node
+}
+
+// sopsTreeBranch builds the tree sops builds from a YAML mapping, with the comments in the
+// places the sops YAML store puts them, so that the walk below sees them in the same order
+func sopsTreeBranch(node *yaml.Node, items []sopsItem, commentsHandled bool) ([]sopsItem, error) {
+	if !commentsHandled {
+		items = appendSopsItemComments(items, node.HeadComment, node.LineComment)
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, content := range node.Content {
+			var err error
+			if items, err = sopsTreeBranch(content, items, false); err != nil {
+				return nil, err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			items = appendSopsItemComments(items, key.HeadComment, key.LineComment)
+			handleValueComments := value.Kind == yaml.ScalarNode || value.Kind == yaml.AliasNode
+			if handleValueComments {
+				items = appendSopsItemComments(items, value.HeadComment, value.LineComment)
+			}
+			treeValue, err := sopsTreeValue(value, handleValueComments)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, sopsItem{key: key.Value, value: treeValue, node: value})
+			if handleValueComments {
+				items = appendSopsItemComments(items, value.FootComment)
+			}
+			items = appendSopsItemComments(items, key.FootComment)
+		}
+	case yaml.AliasNode:
+		var err error
+		if items, err = sopsTreeBranch(node.Alias, items, false); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("sops documents must be mappings")
+	}
+	if !commentsHandled {
+		items = appendSopsItemComments(items, node.FootComment)
+	}
+	return items, nil
+}
+
+func sopsTreeValue(node *yaml.Node, commentsHandled bool) (interface{}, error) {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		list := []interface{}{}
+		if !commentsHandled {
+			list = appendSopsListComments(list, node.HeadComment, node.LineComment)
+		}
+		for _, item := range node.Content {
+			list = appendSopsListComments(list, item.HeadComment, item.LineComment)
+			value, err := sopsTreeValue(item, true)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			list = appendSopsListComments(list, item.FootComment)
+		}
+		if !commentsHandled {
+			list = appendSopsListComments(list, node.FootComment)
+		}
+		return list, nil
+	case yaml.MappingNode:
+		return sopsTreeBranch(node, nil, commentsHandled)
+	case yaml.AliasNode:
+		return sopsTreeValue(node.Alias, false)
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+func appendSopsItemComments(items []sopsItem, comments ...string) []sopsItem {
+	for _, line := range sopsCommentLines(comments) {
+		items = append(items, sopsItem{key: line})
+	}
+	return items
+}
+
+func appendSopsListComments(list []interface{}, comments ...string) []interface{} {
+	for _, line := range sopsCommentLines(comments) {
+		list = append(list, line)
+	}
+	return list
+}
+
+func sopsCommentLines(comments []string) []sopsComment {
+	lines := []sopsComment{}
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			if line != "" {
+				lines = append(lines, sopsComment(line[1:]))
+			}
+		}
+	}
+	return lines
+}
+
+// sopsDecrypter walks the tree the way sops does when decrypting. The comments seen since
+// the last value of each enclosing mapping or list are kept on a stack for the comment
+// regex rules.
+type sopsDecrypter struct {
+	key      []byte
+	metadata sopsMetadata
+	hash     hash.Hash
+}
+
+func (d *sopsDecrypter) branch(items []sopsItem, path []string, comments [][]string) (map[string]interface{}, error) {
+	comments = append(comments, []string{})
+	result := make(map[string]interface{})
+	for _, item := range items {
+		if comment, ok := item.key.(sopsComment); ok {
+			comments[len(comments)-1] = append(comments[len(comments)-1], string(comment))
+			if _, err := d.leaf(comment, path, comments); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		key := item.key.(string)
+		value, err := d.value(item.value, append(append([]string{}, path...), key), comments)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+		comments[len(comments)-1] = []string{}
+	}
+	return result, nil
+}
+
+func (d *sopsDecrypter) value(value interface{}, path []string, comments [][]string) (interface{}, error) {
+	switch v := value.(type) {
+	case []sopsItem:
+		return d.branch(v, path, comments)
+	case []interface{}:
+		comments = append(comments, []string{})
+		result := []interface{}{}
+		for _, item := range v {
+			comment, isComment := item.(sopsComment)
+			if isComment {
+				comments[len(comments)-1] = append(comments[len(comments)-1], string(comment))
+			}
+			decrypted, err := d.value(item, path, comments)
+			if err != nil {
+				return nil, err
+			}
+			if !isComment {
+				comments[len(comments)-1] = []string{}
+			}
+			// comments of lists are written as list items, which decrypt to comments
+			if _, decryptedComment := decrypted.(sopsComment); !decryptedComment {
+				result = append(result, decrypted)
+			}
+		}
+		return result, nil
+	case nil:
+		return nil, nil
+	default:
+		return d.leaf(value, path, comments)
+	}
+}
+
+func (d *sopsDecrypter) leaf(value interface{}, path []string, comments [][]string) (interface{}, error) {
+	comment, isComment := value.(sopsComment)
+	encrypted := d.encrypted(path, comments, isComment)
+	if encrypted {
+		additionalData := strings.Join(path, ":") + ":"
+		if isComment {
+			// comments written by older sops versions were not encrypted
+			if decrypted, err := decryptSopsValue(string(comment), d.key, additionalData); err == nil {
+				value = decrypted
+			}
+		} else if ciphertext, ok := value.(string); ok {
+			var err error
+			if value, err = decryptSopsValue(ciphertext, d.key, additionalData); err != nil {
+				return nil, fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+			}
+		} else {
+			return nil, fmt.Errorf("%s: expected an encrypted value, got %T", strings.Join(path, "."), value)
+		}
+	}
+	if _, isComment := value.(sopsComment); !isComment && (!d.metadata.MACOnlyEncrypted || encrypted) {
+		d.hash.Write(sopsValueBytes(value))
+	}
+	return value, nil
+}
+
+// encrypted applies the sops rules deciding whether the value at path was encrypted
+func (d *sopsDecrypter) encrypted(path []string, comments [][]string, isComment bool) bool {
+	encrypted := true
+	if suffix := d.metadata.UnencryptedSuffix; suffix != "" {
+		for _, p := range path {
+			if strings.HasSuffix(p, suffix) {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if suffix := d.metadata.EncryptedSuffix; suffix != "" {
+		encrypted = false
+		for _, p := range path {
+			if strings.HasSuffix(p, suffix) {
+				encrypted = true
+				break
+			}
+		}
+	}
+	if expression := d.metadata.UnencryptedRegex; expression != "" {
+		for _, p := range path {
+			if matched, _ := regexp.MatchString(expression, p); matched {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if expression := d.metadata.EncryptedRegex; expression != "" {
+		encrypted = false
+		for _, p := range path {
+			if matched, _ := regexp.MatchString(expression, p); matched {
+				encrypted = true
+				break
+			}
+		}
+	}
+	if expression := d.metadata.UnencryptedCommentRegex; expression != "" && sopsCommentMatches(expression, comments, false) {
+		encrypted = false
+	}
+	if expression := d.metadata.EncryptedCommentRegex; expression != "" {
+		// the comment matching the expression is itself left unencrypted
+		encrypted = sopsCommentMatches(expression, comments, isComment)
+	}
+	return encrypted
+}
+
+// sopsCommentMatches reports whether one of the comments on the stack matches expression,
+// skipping the last one when skipLast is set
+func sopsCommentMatches(expression string, comments [][]string, skipLast bool) bool {
+	for i, lines := range comments {
+		for j, line := range lines {
+			if skipLast && i == len(comments)-1 && j == len(lines)-1 {
+				continue
+			}
+			if matched, _ := regexp.MatchString(expression, line); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *sopsDecrypter) verifyMAC() error {
+	lastModified, err := time.Parse(time.RFC3339, d.metadata.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid lastmodified: %v", err)
+	}
+	mac, err := decryptSopsValue(d.metadata.MAC, d.key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("invalid MAC: %v", err)
+	}
+	if computed := fmt.Sprintf("%X", d.hash.Sum(nil)); mac != computed {
+		return fmt.Errorf("MAC mismatch, the file was modified after it was encrypted")
+	}
+	return nil
+}
+
+func decryptSopsValue(value string, key []byte, additionalData string) (interface{}, error) {
+	if value == "" {
+		return "", nil
+	}
+	matches := sopsValuePattern.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, fmt.Errorf("value is not in sops format")
+	}
+	data, err := base64.StdEncoding.DecodeString(matches[1])
+	if err != nil {
+		return nil, err
+	}
+	iv, err := base64.StdEncoding.DecodeString(matches[2])
+	if err != nil {
+		return nil, err
+	}
+	tag, err := base64.StdEncoding.DecodeString(matches[3])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+	switch matches[4] {
+	case "str":
+		return string(plaintext), nil
+	case "comment":
+		return sopsComment(plaintext), nil
+	case "int":
+		return strconv.Atoi(string(plaintext))
+	case "float":
+		return strconv.ParseFloat(string(plaintext), 64)
+	case "bool":
+		return strconv.ParseBool(string(plaintext))
+	case "bytes":
+		return plaintext, nil
+	case "time":
+		var value time.Time
+		err := value.UnmarshalText(plaintext)
+		return value, err
+	default:
+		return nil, fmt.Errorf("unknown sops value type %s", matches[4])
+	}
+}
+
+// sopsValueBytes formats a value the way sops does when computing the MAC
+func sopsValueBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case int:
+		return []byte(strconv.Itoa(v))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case time.Time:
+		text, _ := v.MarshalText()
+		return text
+	case nil:
+		return []byte{}
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
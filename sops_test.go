@@ -0,0 +1,254 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"hash"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keys are sorted so that the JSON variant keeps the order the MAC was computed in
+const sopsPlaintext = `api_key: secret-key
+db:
+  password: db-password
+  port: 5432
+  user: admin
+enabled: true
+hosts:
+  - a.example.com
+  - b.example.com
+region_unencrypted: us-south
+`
+
+// sopsEncrypt encrypts a YAML document the way sops does, with unencrypted_suffix set
+func sopsEncrypt(t *testing.T, recipient age.Recipient, plaintext string) []byte {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(plaintext), &document); err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{3}, 32)
+	mac := sha512.New()
+	sopsEncryptNode(t, document.Content[0], nil, key, mac)
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	var enc bytes.Buffer
+	armorWriter := armor.NewWriter(&enc)
+	writer, err := age.Encrypt(armorWriter, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(key)
+	writer.Close()
+	armorWriter.Close()
+
+	metadata := map[string]interface{}{
+		"age":                []map[string]string{{"recipient": "test", "enc": enc.String()}},
+		"lastmodified":       lastModified,
+		"mac":                sopsEncryptValue(t, fmt.Sprintf("%X", mac.Sum(nil)), "str", key, lastModified),
+		"unencrypted_suffix": "_unencrypted",
+		"version":            "3.9.0",
+	}
+	var metadataNode yaml.Node
+	metadataNode.Encode(metadata)
+	root := document.Content[0]
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "sops"}, &metadataNode)
+	out, err := yaml.Marshal(&document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func sopsEncryptNode(t *testing.T, node *yaml.Node, path []string, key []byte, mac hash.Hash) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			sopsEncryptNode(t, node.Content[i+1], append(append([]string{}, path...), node.Content[i].Value), key, mac)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			sopsEncryptNode(t, item, path, key, mac)
+		}
+	case yaml.ScalarNode:
+		var value interface{}
+		node.Decode(&value)
+		mac.Write(sopsValueBytes(value))
+		if strings.HasSuffix(path[len(path)-1], "_unencrypted") {
+			return
+		}
+		valueType := strings.TrimPrefix(node.Tag, "!!")
+		plaintext := node.Value
+		if valueType == "bool" {
+			plaintext = string(sopsValueBytes(value))
+		}
+		node.Value = sopsEncryptValue(t, plaintext, valueType, key, strings.Join(path, ":")+":")
+		node.Tag = "!!str"
+	}
+}
+
+func sopsEncryptValue(t *testing.T, plaintext, valueType string, key []byte, additionalData string) string {
+	block, _ := aes.NewCipher(key)
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := bytes.Repeat([]byte{byte(len(plaintext))}, 32)
+	sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(tag), valueType)
+}
+
+func setSopsFiles(t *testing.T) *age.X25519Identity {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(SOPS_AGE_KEY_ENV, identity.String())
+	t.Setenv("SOPS_FALLBACK", "fallback")
+
+	os.MkdirAll("test/cases/sops", 0755)
+	t.Cleanup(func() { os.RemoveAll("test/cases/sops") })
+	encrypted := sopsEncrypt(t, identity.Recipient(), sopsPlaintext)
+	ioutil.WriteFile("test/cases/sops/secrets.enc.yaml", encrypted, 0644)
+
+	var tree yaml.Node
+	yaml.Unmarshal(encrypted, &tree)
+	var document map[string]interface{}
+	tree.Decode(&document)
+	encryptedJSON, _ := json.Marshal(document)
+	ioutil.WriteFile("test/cases/sops/secrets.enc.json", encryptedJSON, 0644)
+
+	tampered := strings.Replace(string(encrypted), "us-south", "eu-de", 1)
+	ioutil.WriteFile("test/cases/sops/tampered.enc.yaml", []byte(tampered), 0644)
+
+	Initialize("server/config/sops/mappings.json")
+	return identity
+}
+
+func TestSopsFile(t *testing.T) {
+	setSopsFiles(t)
+	for name, expected := range map[string]string{
+		"sops_var1": "db-password",
+		"sops_var2": "secret-key",
+		"sops_var3": "5432",
+		"sops_var4": "us-south",
+		"sops_var5": "fallback",
+	} {
+		testString, _ := GetString(name)
+		if testString != expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", name, testString, expected)
+		}
+	}
+}
+
+func TestSopsFileDocument(t *testing.T) {
+	setSopsFiles(t)
+	value, ok := processSopsSearchPattern([]string{PREFIX_PATTERN_SOPS, "/test/cases/sops/secrets.enc.yaml"})
+	if !ok {
+		t.Fatalf("Expected the sops file to decrypt\n")
+	}
+	var document map[string]interface{}
+	json.Unmarshal([]byte(value), &document)
+	if _, found := document["sops"]; found {
+		t.Errorf("Metadata should not be part of the decrypted document\n")
+	}
+	if document["enabled"] != true || len(document["hosts"].([]interface{})) != 2 {
+		t.Errorf("Unexpected document %s\n", value)
+	}
+}
+
+func TestSopsFileKeys(t *testing.T) {
+	identity := setSopsFiles(t)
+	os.Unsetenv(SOPS_AGE_KEY_ENV)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	if _, ok := processSopsSearchPattern([]string{PREFIX_PATTERN_SOPS, "/test/cases/sops/secrets.enc.yaml"}); ok {
+		t.Errorf("Expected decryption to fail without a key\n")
+	}
+
+	keyFile := t.TempDir() + "/keys.txt"
+	ioutil.WriteFile(keyFile, []byte(identity.String()+"\n"), 0600)
+	t.Setenv(SOPS_AGE_KEY_FILE_ENV, keyFile)
+	if value, ok := processSopsSearchPattern([]string{PREFIX_PATTERN_SOPS, "/test/cases/sops/secrets.enc.yaml", "$.db.user"}); !ok || value != "admin" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "admin")
+	}
+}
+
+// the test/cases/test-sops-* files were encrypted by the sops CLI for the age key in
+// test-sops-age-key.txt, with comments and the --unencrypted-suffix and --encrypted-regex rules
+func TestSopsCLIFiles(t *testing.T) {
+	unsetenv(t, SOPS_AGE_KEY_ENV)
+	t.Setenv(SOPS_AGE_KEY_FILE_ENV, "test/cases/test-sops-age-key.txt")
+	for _, test := range []struct {
+		file     string
+		expected string
+	}{
+		{"test/cases/test-sops-suffix.enc.yaml", `{"api_key":"secret-key","db":{"password":"db-password","port":5432,"user":"admin"},"enabled":true,"hosts":["a.example.com","b.example.com"],"region_unencrypted":"us-south","servers":[{"name":"primary","token":"primary-token"},{"name":"replica","token":"replica-token"}]}`},
+		{"test/cases/test-sops-regex.enc.yaml", `{"db":{"host":"db.internal","password":"db-password","port":5432},"servers":[{"name":"primary","token":"primary-token"},{"name":"replica","token":"replica-token"}]}`},
+		{"test/cases/test-sops.enc.json", `{"api_key":"secret-key","db":{"password":"db-password","port":5432},"servers":[{"name":"primary","token":"primary-token"}]}`},
+	} {
+		content, _ := ioutil.ReadFile(test.file)
+		if actual, err := decryptSops(content); err != nil || string(actual) != test.expected {
+			t.Errorf("%s Got: \t%s %v\n Wanted: \t%s\n", test.file, actual, err, test.expected)
+		}
+	}
+
+	// comments are not part of the MAC, unencrypted values are
+	content, _ := ioutil.ReadFile("test/cases/test-sops-suffix.enc.yaml")
+	lines := strings.SplitN(string(content), "\n", 2)
+	if _, err := decryptSops([]byte(lines[1])); err != nil {
+		t.Errorf("Expected the file to decrypt without its first comment, got %v\n", err)
+	}
+	tampered := strings.Replace(string(content), "region_unencrypted: us-south", "region_unencrypted: eu-de", 1)
+	if _, err := decryptSops([]byte(tampered)); err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "MAC mismatch")
+	}
+}
+
+func TestSopsValueBytes(t *testing.T) {
+	for _, test := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{"text", "text"},
+		{5432, "5432"},
+		{1.5, "1.5"},
+		{true, "True"},
+		{false, "False"},
+		{time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), "2026-01-02T03:04:05Z"},
+		{nil, ""},
+	} {
+		if actual := string(sopsValueBytes(test.value)); actual != test.expected {
+			t.Errorf("Got: \t%s\n Wanted: \t%s\n", actual, test.expected)
+		}
+	}
+}
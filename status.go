@@ -0,0 +1,151 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// SOURCE_TEMPLATE is reported as the source of mappings resolved from a template
+const SOURCE_TEMPLATE = "template"
+
+// MappingStatus describes how a mapping was resolved by the last Initialize.
+// Mappings of version 2 files are reported as "mapping.key". Pattern has the userinfo and
+// query string of URLs removed, as they may carry credentials.
+type MappingStatus struct {
+	Name       string    `json:"name"`
+	Resolved   bool      `json:"resolved"`
+	Pattern    string    `json:"pattern,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+
+	// searchPattern is the pattern as written, which Watch watches
+	searchPattern string
+}
+
+// LoadStatus describes the mappings files loaded by Initialize. Loads and the
+// timestamps only count mappings files that could be read. LastError is the error of
+// the last load, empty when it succeeded.
+type LoadStatus struct {
+	MappingsFile string        `json:"mappingsFile"`
+	FirstLoad    time.Time     `json:"firstLoad"`
+	LastLoad     time.Time     `json:"lastLoad"`
+	Loads        int           `json:"loads"`
+	Failures     int           `json:"failures"`
+	LastError    string        `json:"lastError,omitempty"`
+	LastDuration time.Duration `json:"lastDuration"`
+}
+
+// attemptKey identifies the resolution attempts counted per mapping, source and outcome
+type attemptKey struct {
+	mapping  string
+	source   string
+	resolved bool
+}
+
+var statusMutex sync.RWMutex
+var mappingStatuses = make(map[string]MappingStatus)
+var loadStatus LoadStatus
+var resolutionAttempts = make(map[attemptKey]uint64)
+
+func patternSource(pattern string) string {
+	if index := strings.Index(pattern, ":"); index >= 0 {
+		return pattern[:index]
+	}
+	return pattern
+}
+
+func recordAttempt(name, pattern string, resolved bool) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	resolutionAttempts[attemptKey{mapping: name, source: patternSource(pattern), resolved: resolved}]++
+}
+
+func recordStatus(name, pattern string, resolved bool) {
+	source := patternSource(pattern)
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	mappingStatuses[name] = MappingStatus{
+		Name:       name,
+		Resolved:   resolved,
+		Pattern:    redactSearchPattern(pattern),
+		Source:     source,
+		ResolvedAt: time.Now(),
+
+		searchPattern: pattern,
+	}
+}
+
+// resetMappingStatuses forgets the statuses of the previous load, whose mappings may not be
+// defined by the next mappings file
+func resetMappingStatuses() {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	mappingStatuses = make(map[string]MappingStatus)
+}
+
+func recordLoad(mappingsFilePath string, err error, duration time.Duration) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	if err != nil {
+		loadStatus.Failures++
+		loadStatus.LastError = err.Error()
+		return
+	}
+	now := time.Now()
+	if loadStatus.Loads == 0 {
+		loadStatus.FirstLoad = now
+	}
+	loadStatus.MappingsFile = mappingsFilePath
+	loadStatus.LastError = ""
+	loadStatus.LastLoad = now
+	loadStatus.LastDuration = duration
+	loadStatus.Loads++
+}
+
+func mappingStatus(name string) (MappingStatus, bool) {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	status, ok := mappingStatuses[name]
+	return status, ok
+}
+
+func mappingResolved(name string) bool {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	return mappingStatuses[name].Resolved
+}
+
+// GetMappingStatuses returns the resolution status of every mapping, sorted by name
+func GetMappingStatuses() []MappingStatus {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	statuses := make([]MappingStatus, 0, len(mappingStatuses))
+	for _, name := range sortedKeys(mappingStatuses) {
+		statuses = append(statuses, mappingStatuses[name])
+	}
+	return statuses
+}
+
+// GetLoadStatus returns when and how often mappings files were loaded
+func GetLoadStatus() LoadStatus {
+	statusMutex.RLock()
+	defer statusMutex.RUnlock()
+	return loadStatus
+}
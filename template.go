@@ -0,0 +1,209 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/attribute"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const TEMPLATE_PREFIX_ENV = PREFIX_PATTERN_ENV + ":"
+const TEMPLATE_ESCAPE_URL = "url"
+
+// templateReference matches ${name}, ${env:NAME} and ${name|url}
+var templateReference = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// resolveTemplates resolves the "template" mappings of a mappings file once all
+// regular mappings are loaded. Templates may reference each other, so they are
+// resolved in dependency order; a template with an unresolved reference or a
+// reference cycle is left unresolved and its error is returned.
+func resolveTemplates(ctx context.Context, templates map[string]gjson.Result) map[string]error {
+	errs := make(map[string]error)
+	order, cycles := sortTemplates(templates)
+	for name, err := range cycles {
+		errs[name] = err
+	}
+	for _, name := range order {
+		if _, failed := errs[name]; failed {
+			continue
+		}
+		if ctx.Err() != nil {
+			errs[name] = fmt.Errorf("template mapping %s: %v", name, ctx.Err())
+			markIncomplete(ctx, name)
+			continue
+		}
+		mappingCtx, span := startSpan(ctx, "ibmcloudenv.mapping", attribute.String("ibmcloudenv.mapping", name))
+		config := templates[name]
+		value, err := renderTemplate(mappingCtx, config.Get("template").String(), config.Get("escape").String())
+		if err != nil {
+			errs[name] = fmt.Errorf("template mapping %s: %v", name, err)
+			endSpan(span, false, SOURCE_TEMPLATE)
+			continue
+		}
+		storeMapping(name, value)
+		recordStatus(name, SOURCE_TEMPLATE, true)
+		endSpan(span, true, SOURCE_TEMPLATE)
+	}
+	for _, name := range sortedKeys(errs) {
+		log.Errorln(errs[name])
+		recordStatus(name, "", false)
+	}
+	return errs
+}
+
+// sortTemplates orders templates so that every template comes after the
+// templates it references. Templates that are part of, or depend on, a cycle
+// are reported as errors instead.
+func sortTemplates(templates map[string]gjson.Result) ([]string, map[string]error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	errs := make(map[string]error)
+	order := []string{}
+
+	var visit func(name string, path []string) bool
+	visit = func(name string, path []string) bool {
+		switch state[name] {
+		case visiting:
+			cycle := append(append([]string{}, path[indexOf(path, name):]...), name)
+			for _, member := range cycle {
+				errs[member] = fmt.Errorf("template mapping %s: reference cycle %s", member, strings.Join(cycle, " -> "))
+			}
+			return false
+		case visited:
+			_, failed := errs[name]
+			return !failed
+		}
+		state[name] = visiting
+		path = append(path, name)
+		ok := true
+		for _, ref := range templateReferences(templates[name].Get("template").String()) {
+			if _, isTemplate := templates[ref]; !isTemplate {
+				continue
+			}
+			if !visit(ref, path) {
+				ok = false
+			}
+		}
+		state[name] = visited
+		if !ok {
+			if _, failed := errs[name]; !failed {
+				errs[name] = fmt.Errorf("template mapping %s: depends on a template that could not be resolved", name)
+			}
+			return false
+		}
+		order = append(order, name)
+		return true
+	}
+
+	for _, name := range sortedKeys(templates) {
+		visit(name, nil)
+	}
+	return order, errs
+}
+
+// templateReferences returns the mapping names referenced by a template,
+// ignoring environment variable references and escape modifiers.
+func templateReferences(template string) []string {
+	refs := []string{}
+	for _, match := range templateReference.FindAllStringSubmatch(template, -1) {
+		name, _ := splitTemplateReference(match[1])
+		if !strings.HasPrefix(name, TEMPLATE_PREFIX_ENV) {
+			refs = append(refs, name)
+		}
+	}
+	return refs
+}
+
+func renderTemplate(ctx context.Context, template, escape string) (string, error) {
+	var err error
+	value := templateReference.ReplaceAllStringFunc(template, func(match string) string {
+		if err != nil {
+			return ""
+		}
+		name, modifier := splitTemplateReference(match[2 : len(match)-1])
+		if modifier == "" {
+			modifier = escape
+		}
+		var value string
+		var ok bool
+		if strings.HasPrefix(name, TEMPLATE_PREFIX_ENV) {
+			value, ok = os.LookupEnv(name[len(TEMPLATE_PREFIX_ENV):])
+		} else {
+			value, ok = GetStringContext(ctx, name)
+		}
+		if !ok {
+			err = fmt.Errorf("unresolved reference %s", match)
+			return ""
+		}
+		switch modifier {
+		case "":
+			return value
+		case TEMPLATE_ESCAPE_URL:
+			return urlEscape(value)
+		default:
+			err = fmt.Errorf("unknown escape %q in reference %s", modifier, match)
+			return ""
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// urlEscape escapes every character that is not allowed unescaped in any URL component
+func urlEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+func splitTemplateReference(ref string) (string, string) {
+	parts := strings.SplitN(ref, "|", 2)
+	if len(parts) == 2 {
+		return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	}
+	return strings.TrimSpace(parts[0]), ""
+}
+
+func indexOf(list []string, item string) int {
+	for i, v := range list {
+		if v == item {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
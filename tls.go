@@ -0,0 +1,194 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"strings"
+	"sync"
+)
+
+// TLSOptions selects the certificates inside a mapping for GetTLSConfig.
+// The paths use the same dotted syntax as GetDictionary().Get(). When CAPath is
+// empty the mapping value itself is used, or, for JSON values, the first
+// "certificate_base64" or "ca" key found anywhere in it, which covers IBM Cloud
+// Databases credentials.
+type TLSOptions struct {
+	CAPath     string
+	CertPath   string
+	KeyPath    string
+	ServerName string
+	MinVersion uint16
+}
+
+type tlsConfigEntry struct {
+	source  string
+	options TLSOptions
+	config  *tls.Config
+	cert    *tls.Certificate
+}
+
+var tlsConfigsMutex sync.Mutex
+var tlsConfigs = make(map[string]*tlsConfigEntry)
+
+// GetTLSConfig builds a *tls.Config trusting the CA certificate held by the mapping,
+// with an optional client certificate and key. The configuration is cached and rebuilt
+// when the mapping value changes after Initialize is called again; client certificates
+// of previously returned configurations follow the latest value as well. The CA
+// certificates of a returned configuration do not change, so call GetTLSConfig again
+// after Initialize to trust a new CA certificate.
+func GetTLSConfig(name string, opts *TLSOptions) (*tls.Config, error) {
+	options := TLSOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	source, ok := GetString(name)
+	if !ok {
+		return nil, fmt.Errorf("mapping %s does not exist", name)
+	}
+
+	tlsConfigsMutex.Lock()
+	defer tlsConfigsMutex.Unlock()
+	entry, cached := tlsConfigs[name]
+	if cached && entry.source == source && entry.options == options {
+		return entry.config.Clone(), nil
+	}
+
+	config, cert, err := buildTLSConfig(source, options)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %v", name, err)
+	}
+	entry = &tlsConfigEntry{source: source, options: options, config: config, cert: cert}
+	tlsConfigs[name] = entry
+	if cert != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			tlsConfigsMutex.Lock()
+			defer tlsConfigsMutex.Unlock()
+			if latest, ok := tlsConfigs[name]; ok && latest.cert != nil {
+				return latest.cert, nil
+			}
+			return cert, nil
+		}
+	}
+	return config.Clone(), nil
+}
+
+func buildTLSConfig(source string, options TLSOptions) (*tls.Config, *tls.Certificate, error) {
+	caPEM, err := lookupPEM(source, options.CAPath, "certificate_base64", "ca")
+	if err != nil {
+		return nil, nil, fmt.Errorf("CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if err := appendCertificates(pool, caPEM); err != nil {
+		return nil, nil, fmt.Errorf("CA certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		RootCAs:    pool,
+		ServerName: options.ServerName,
+		MinVersion: options.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if options.CertPath == "" && options.KeyPath == "" {
+		return config, nil, nil
+	}
+	if options.CertPath == "" || options.KeyPath == "" {
+		return nil, nil, fmt.Errorf("client certificate and key must be configured together")
+	}
+	certPEM, err := lookupPEM(source, options.CertPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("client certificate: %v", err)
+	}
+	keyPEM, err := lookupPEM(source, options.KeyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("client key: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("client certificate: %v", err)
+	}
+	return config, &cert, nil
+}
+
+// lookupPEM finds a PEM block in the mapping value, either at path or, when path
+// is empty, in the value itself or under one of the default keys.
+func lookupPEM(source, path string, defaultKeys ...string) ([]byte, error) {
+	value := source
+	if path != "" {
+		result := gjson.Get(source, path)
+		if !result.Exists() {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+		value = result.String()
+	} else if gjson.Valid(source) && gjson.Parse(source).IsObject() {
+		found := false
+		for _, key := range defaultKeys {
+			if value, found = deepSearch(gjson.Parse(source), key); found {
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no certificate found, expected one of %s", strings.Join(defaultKeys, ", "))
+		}
+	}
+	return decodePEM(value)
+}
+
+// decodePEM accepts PEM text or base64 encoded PEM text
+func decodePEM(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || !strings.Contains(string(decoded), "-----BEGIN") {
+		return nil, fmt.Errorf("value is neither PEM nor base64 encoded PEM")
+	}
+	return decoded, nil
+}
+
+func appendCertificates(pool *x509.CertPool, data []byte) error {
+	count := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("no certificates found in PEM data")
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func generateTestCertificate(t *testing.T, commonName string, dnsNames ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(certPEM), string(keyPEM)
+}
+
+func databaseCredentials(certPEM string) string {
+	creds := map[string]interface{}{
+		"connection": map[string]interface{}{
+			"postgres": map[string]interface{}{
+				"certificate": map[string]interface{}{
+					"certificate_base64": base64.StdEncoding.EncodeToString([]byte(certPEM)),
+				},
+			},
+		},
+	}
+	bytes, _ := json.Marshal(creds)
+	return string(bytes)
+}
+
+func TestTLSConfigFromDatabaseCredentials(t *testing.T) {
+	certPEM, _ := generateTestCertificate(t, "test-ca")
+	loadedMappings["tls_var1"] = databaseCredentials(certPEM)
+
+	config, err := GetTLSConfig("tls_var1", &TLSOptions{ServerName: "db.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs == nil || config.ServerName != "db.example.com" {
+		t.Errorf("Unexpected TLS config: %+v\n", config)
+	}
+}
+
+func TestTLSConfigFromPlainPEM(t *testing.T) {
+	certPEM, keyPEM := generateTestCertificate(t, "test-client")
+	bytes, _ := json.Marshal(map[string]string{"ca": certPEM, "cert": certPEM, "key": keyPEM})
+	loadedMappings["tls_var2"] = string(bytes)
+
+	config, err := GetTLSConfig("tls_var2", &TLSOptions{CertPath: "cert", KeyPath: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := config.GetClientCertificate(nil)
+	if err != nil || cert == nil {
+		t.Errorf("Client certificate not configured: %v\n", err)
+	}
+}
+
+func TestTLSConfigRefreshesOnReload(t *testing.T) {
+	certPEM, _ := generateTestCertificate(t, "first-ca")
+	loadedMappings["tls_var3"] = certPEM
+	first, err := GetTLSConfig("tls_var3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, _ := GetTLSConfig("tls_var3", nil)
+	if !first.RootCAs.Equal(cached.RootCAs) {
+		t.Errorf("TLS config should be cached while the mapping is unchanged\n")
+	}
+
+	certPEM, _ = generateTestCertificate(t, "second-ca")
+	loadedMappings["tls_var3"] = base64.StdEncoding.EncodeToString([]byte(certPEM))
+	second, err := GetTLSConfig("tls_var3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RootCAs.Equal(second.RootCAs) {
+		t.Errorf("TLS config was not rebuilt after the mapping changed\n")
+	}
+}
+
+func TestTLSConfigInvalidCertificates(t *testing.T) {
+	loadedMappings["tls_var4"] = "not a certificate"
+	if _, err := GetTLSConfig("tls_var4", nil); err == nil {
+		t.Errorf("Expected error for invalid CA certificate\n")
+	}
+
+	loadedMappings["tls_var5"] = "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----"
+	if _, err := GetTLSConfig("tls_var5", nil); err == nil {
+		t.Errorf("Expected error for malformed CA certificate\n")
+	}
+
+	certPEM, _ := generateTestCertificate(t, "test-ca")
+	loadedMappings["tls_var6"] = databaseCredentials(certPEM)
+	if _, err := GetTLSConfig("tls_var6", &TLSOptions{CertPath: "cert"}); err == nil {
+		t.Errorf("Expected error for client certificate without key\n")
+	}
+
+	if _, err := GetTLSConfig("tls_missing", nil); err == nil {
+		t.Errorf("Expected error for missing mapping\n")
+	}
+}
+
+func TestTLSConfigVerifiesServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	serverPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	otherPEM, _ := generateTestCertificate(t, "other-ca")
+	loadedMappings["tls_var7"] = otherPEM
+	config, err := GetTLSConfig("tls_var7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("Expected the server certificate not to be trusted\n")
+	}
+
+	// the configuration is fetched again after a reload to trust the new CA certificate
+	loadedMappings["tls_var7"] = serverPEM
+	config, err = GetTLSConfig("tls_var7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the reloaded CA certificate to be trusted: %v\n", err)
+	}
+	response.Body.Close()
+}
+
+func TestTLSConfigVerifiesHostname(t *testing.T) {
+	// a trusted certificate for another host, served on an IP address
+	certPEM, keyPEM := generateTestCertificate(t, "other.example.com", "other.example.com")
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	loadedMappings["tls_var8"] = certPEM
+	config, err := GetTLSConfig("tls_var8", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("Expected a certificate for other.example.com to be rejected for %s\n", server.URL)
+	}
+
+	config, _ = GetTLSConfig("tls_var8", &TLSOptions{ServerName: "other.example.com"})
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the certificate to be accepted for its ServerName: %v\n", err)
+	}
+	response.Body.Close()
+}
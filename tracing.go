@@ -0,0 +1,101 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"regexp"
+	"sync"
+)
+
+const TRACER_NAME = "github.com/ibm-developer/ibm-cloud-env-golang"
+
+var tracerMutex sync.RWMutex
+var tracerProvider trace.TracerProvider
+
+// userinfo and query strings of URLs in http and https search patterns may carry credentials
+var urlSecrets = regexp.MustCompile(`^//[^/@]*@|\?.*$`)
+
+// SetTracerProvider sets the OpenTelemetry tracer provider used for the spans around
+// Initialize, each mapping and each search pattern. Without it the global provider is
+// used, which does nothing unless the application configured one.
+func SetTracerProvider(provider trace.TracerProvider) {
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+	tracerProvider = provider
+}
+
+func tracer() trace.Tracer {
+	tracerMutex.RLock()
+	defer tracerMutex.RUnlock()
+	if tracerProvider != nil {
+		return tracerProvider.Tracer(TRACER_NAME)
+	}
+	return otel.GetTracerProvider().Tracer(TRACER_NAME)
+}
+
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan records the outcome of a resolution and ends the span
+func endSpan(span trace.Span, resolved bool, source string) {
+	outcome := "not_found"
+	if resolved {
+		outcome = "resolved"
+	}
+	span.SetAttributes(attribute.String("ibmcloudenv.outcome", outcome))
+	if source != "" {
+		span.SetAttributes(attribute.String("ibmcloudenv.source", source))
+	}
+	span.End()
+}
+
+// redactSearchPattern is redactPattern keeping the prefix of the search pattern
+func redactSearchPattern(pattern string) string {
+	source := patternSource(pattern)
+	if len(source) == len(pattern) {
+		return pattern
+	}
+	return source + ":" + redactPattern(pattern)
+}
+
+// redactPattern returns the location part of a search pattern without its prefix,
+// stripping userinfo and query strings from the URL of http and https patterns
+func redactPattern(pattern string) string {
+	source := patternSource(pattern)
+	if len(source) == len(pattern) {
+		return pattern
+	}
+	if source != "http" && source != "https" {
+		return pattern[len(source)+1:]
+	}
+	url, selector := splitHTTPPattern(splitSearchPattern(pattern))
+	location := urlSecrets.ReplaceAllStringFunc(url[len(source)+1:], func(secret string) string {
+		if secret[0] == '/' {
+			return "//"
+		}
+		return ""
+	})
+	if selector != "" {
+		return location + ":" + selector
+	}
+	return location
+}
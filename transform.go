@@ -0,0 +1,112 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"strings"
+	"sync"
+)
+
+const TRANSFORM_BASE64_DECODE = "base64decode"
+const TRANSFORM_TRIM = "trim"
+const TRANSFORM_JSON_UNWRAP = "jsonunwrap"
+const TRANSFORM_LOWERCASE = "lowercase"
+const TRANSFORM_UPPERCASE = "uppercase"
+
+// TransformFunc post-processes a value returned by a search pattern
+type TransformFunc func(value string) (string, error)
+
+var transformsMutex sync.RWMutex
+var transforms = map[string]TransformFunc{
+	TRANSFORM_BASE64_DECODE: base64DecodeTransform,
+	TRANSFORM_TRIM:          trimTransform,
+	TRANSFORM_JSON_UNWRAP:   jsonUnwrapTransform,
+	TRANSFORM_LOWERCASE:     func(value string) (string, error) { return strings.ToLower(value), nil },
+	TRANSFORM_UPPERCASE:     func(value string) (string, error) { return strings.ToUpper(value), nil },
+}
+
+// RegisterTransform makes a custom transform available to mappings under the given name.
+// Registering an existing name replaces it, including the built-in transforms.
+func RegisterTransform(name string, transform TransformFunc) {
+	transformsMutex.Lock()
+	defer transformsMutex.Unlock()
+	transforms[name] = transform
+}
+
+func lookupTransform(name string) (TransformFunc, bool) {
+	transformsMutex.RLock()
+	defer transformsMutex.RUnlock()
+	transform, ok := transforms[name]
+	return transform, ok
+}
+
+// applyTransforms runs each chain of transforms in order. A chain is either a
+// single transform name or an array of names. A failing or unknown transform
+// fails the whole search pattern so the next one is tried.
+func applyTransforms(mappingName, value string, chains ...gjson.Result) (string, bool) {
+	for _, chain := range chains {
+		names := []gjson.Result{chain}
+		if chain.IsArray() {
+			names = chain.Array()
+		}
+		for _, name := range names {
+			if !name.Exists() {
+				continue
+			}
+			transform, ok := lookupTransform(name.String())
+			if !ok {
+				log.Errorln("Unknown transform", name.String(), "for mapping", mappingName)
+				return "", false
+			}
+			transformed, err := transform(value)
+			if err != nil {
+				log.Errorln("Transform", name.String(), "failed for mapping", mappingName, err)
+				return "", false
+			}
+			value = transformed
+		}
+	}
+	return value, true
+}
+
+func base64DecodeTransform(value string) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(trimmed); err == nil {
+			return string(decoded), nil
+		}
+	}
+	return "", fmt.Errorf("value is not valid base64")
+}
+
+func trimTransform(value string) (string, error) {
+	return strings.TrimSpace(value), nil
+}
+
+// jsonUnwrapTransform turns a JSON encoded string such as "\"{\\\"a\\\":1}\"" into its content
+func jsonUnwrapTransform(value string) (string, error) {
+	var unwrapped string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(value)), &unwrapped); err != nil {
+		return "", fmt.Errorf("value is not a JSON string: %v", err)
+	}
+	return unwrapped, nil
+}
@@ -0,0 +1,85 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"os"
+	"testing"
+)
+
+func setTransformEnvVariables() {
+	// base64 of "-----BEGIN CERTIFICATE-----\n"
+	os.Setenv("TRANSFORM_CERTIFICATE", `{"certificate_base64": "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCg=="}`)
+	os.Setenv("TRANSFORM_WRAPPED", `"wrapped value"`)
+	os.Setenv("TRANSFORM_FALLBACK", "Fallback Value")
+
+	Initialize("server/config/transform/mappings.json")
+}
+
+func TestPatternTransforms(t *testing.T) {
+	setTransformEnvVariables()
+	testString, _ := GetString("transform_var1")
+	if testString != "-----BEGIN CERTIFICATE-----" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "-----BEGIN CERTIFICATE-----")
+	}
+}
+
+func TestMappingTransforms(t *testing.T) {
+	setTransformEnvVariables()
+	testString, _ := GetString("transform_var2")
+	if testString != "secret-with-newline" {
+		t.Errorf("Got: \t%q\n Wanted: \t%q\n", testString, "secret-with-newline")
+	}
+
+	testString, _ = GetString("transform_var3")
+	if testString != "WRAPPED VALUE" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "WRAPPED VALUE")
+	}
+}
+
+func TestFailedTransformFallsThrough(t *testing.T) {
+	setTransformEnvVariables()
+	testString, _ := GetString("transform_var4")
+	if testString != "fallback value" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "fallback value")
+	}
+
+	if _, ok := GetString("transform_var5"); ok {
+		t.Errorf("Unknown transform should not resolve\n")
+	}
+}
+
+func TestRegisterTransform(t *testing.T) {
+	RegisterTransform("reverse", func(value string) (string, error) {
+		runes := []rune(value)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	})
+	defer func() {
+		transformsMutex.Lock()
+		delete(transforms, "reverse")
+		transformsMutex.Unlock()
+	}()
+
+	setTransformEnvVariables()
+	testString, _ := GetString("transform_var5")
+	if testString != "eulaV kcabllaF" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", testString, "eulaV kcabllaF")
+	}
+}
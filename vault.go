@@ -0,0 +1,377 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const PREFIX_PATTERN_VAULT = "vault"
+
+// Vault connection, using the environment variables of the Vault CLI
+const VAULT_ADDR_ENV = "VAULT_ADDR"
+const VAULT_NAMESPACE_ENV = "VAULT_NAMESPACE"
+const VAULT_CACERT_ENV = "VAULT_CACERT"
+
+// Vault authentication. VAULT_TOKEN is used as is; otherwise the AppRole or Kubernetes
+// variables select the auth method to log in with. VAULT_AUTH_MOUNT overrides the path the
+// method is mounted at, which defaults to "approle" and "kubernetes".
+const VAULT_TOKEN_ENV = "VAULT_TOKEN"
+const VAULT_ROLE_ID_ENV = "VAULT_ROLE_ID"
+const VAULT_SECRET_ID_ENV = "VAULT_SECRET_ID"
+const VAULT_SECRET_ID_FILE_ENV = "VAULT_SECRET_ID_FILE"
+const VAULT_KUBERNETES_ROLE_ENV = "VAULT_KUBERNETES_ROLE"
+const VAULT_KUBERNETES_TOKEN_FILE_ENV = "VAULT_KUBERNETES_TOKEN_FILE"
+const VAULT_AUTH_MOUNT_ENV = "VAULT_AUTH_MOUNT"
+
+const VAULT_KUBERNETES_DEFAULT_TOKEN_FILE = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Leased secrets and login tokens are read again once this fraction of their lease has passed
+const vaultRefreshFraction = 0.8
+
+// vaultLease is a cached secret or login token. Secrets without a lease have a zero
+// refreshAt and are kept until the next Initialize.
+type vaultLease struct {
+	value     string
+	refreshAt time.Time
+}
+
+func (l vaultLease) valid(now time.Time) bool {
+	return l.refreshAt.IsZero() || now.Before(l.refreshAt)
+}
+
+var vaultMutex sync.Mutex
+var vaultSecrets = make(map[string]vaultLease)
+var vaultTokens = make(map[string]vaultLease)
+var vaultClients = make(map[string]*http.Client)
+var vaultGroup singleflight.Group
+var vaultNow = time.Now
+
+// vaultConfig is the Vault server and auth method configured by the environment
+type vaultConfig struct {
+	addr      string
+	namespace string
+	client    *http.Client
+	method    string
+	mount     string
+	role      string
+}
+
+// processVaultSearchPattern handles vault:path[:$.JSONPath]. The path is the API path of the
+// secret without /v1/, e.g. secret/data/myapp for KV v2, and the selector applies to the data
+// of the response, so KV v2 values are under $.data.
+func processVaultSearchPattern(ctx context.Context, patternComponents []string) (string, bool) {
+	if len(patternComponents) < 2 || patternComponents[1] == "" {
+		return "", false
+	}
+	secret, err := readVaultSecret(ctx, patternComponents[1])
+	if err != nil {
+		log.Errorln("Failed to read Vault secret", patternComponents[1], err)
+		return "", false
+	}
+	if len(patternComponents) == 3 {
+		return processSelector(secret, patternComponents[2])
+	}
+	return secret, true
+}
+
+// resetVault drops the secrets read without a lease, so that a new load reads them again
+func resetVault() {
+	vaultMutex.Lock()
+	defer vaultMutex.Unlock()
+	for key, secret := range vaultSecrets {
+		if secret.refreshAt.IsZero() {
+			delete(vaultSecrets, key)
+		}
+	}
+}
+
+// watchVaultSecret blocks until 80% of the lease of a secret has passed, so that Watch reads
+// leased secrets again before they expire. Secrets without a lease are never read again by
+// Watch; it returns when ctx ends.
+func watchVaultSecret(ctx context.Context, patternComponents []string) error {
+	if len(patternComponents) < 2 || patternComponents[1] == "" {
+		return fmt.Errorf("no secret path")
+	}
+	config, err := loadVaultConfig()
+	if err != nil {
+		return err
+	}
+	vaultMutex.Lock()
+	secret, ok := vaultSecrets[config.addr+"|"+config.namespace+"|"+strings.Trim(patternComponents[1], "/")]
+	vaultMutex.Unlock()
+	if ok && secret.refreshAt.IsZero() {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if !ok || !secret.valid(vaultNow()) {
+		// the last read failed or the lease ended before Watch started, read the secret now
+		// so that a failing read waits for the retry delay
+		_, err := readVaultSecret(ctx, patternComponents[1])
+		return err
+	}
+	timer := time.NewTimer(secret.refreshAt.Sub(vaultNow()))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func loadVaultConfig() (vaultConfig, error) {
+	config := vaultConfig{
+		addr:      strings.TrimRight(os.Getenv(VAULT_ADDR_ENV), "/"),
+		namespace: os.Getenv(VAULT_NAMESPACE_ENV),
+		mount:     os.Getenv(VAULT_AUTH_MOUNT_ENV),
+	}
+	if config.addr == "" {
+		return config, fmt.Errorf("%s is not set", VAULT_ADDR_ENV)
+	}
+	switch {
+	case os.Getenv(VAULT_TOKEN_ENV) != "":
+		config.method = "token"
+	case os.Getenv(VAULT_ROLE_ID_ENV) != "":
+		config.method = "approle"
+		config.role = os.Getenv(VAULT_ROLE_ID_ENV)
+	case os.Getenv(VAULT_KUBERNETES_ROLE_ENV) != "":
+		config.method = "kubernetes"
+		config.role = os.Getenv(VAULT_KUBERNETES_ROLE_ENV)
+	default:
+		return config, fmt.Errorf("no Vault authentication configured, set %s, %s or %s", VAULT_TOKEN_ENV, VAULT_ROLE_ID_ENV, VAULT_KUBERNETES_ROLE_ENV)
+	}
+	if config.mount == "" {
+		config.mount = config.method
+	}
+	client, err := vaultHTTPClient(os.Getenv(VAULT_CACERT_ENV))
+	config.client = client
+	return config, err
+}
+
+// vaultHTTPClient returns a client trusting the CA certificates of caCert in addition to the
+// system ones, reusing it across requests
+func vaultHTTPClient(caCert string) (*http.Client, error) {
+	vaultMutex.Lock()
+	defer vaultMutex.Unlock()
+	if client, ok := vaultClients[caCert]; ok {
+		return client, nil
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s contains no certificates", caCert)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		client.Transport = transport
+	}
+	vaultClients[caCert] = client
+	return client, nil
+}
+
+// readVaultSecret returns the data of a secret as JSON, from the cache while its lease is valid
+func readVaultSecret(ctx context.Context, path string) (string, error) {
+	config, err := loadVaultConfig()
+	if err != nil {
+		return "", err
+	}
+	key := config.addr + "|" + config.namespace + "|" + strings.Trim(path, "/")
+	vaultMutex.Lock()
+	secret, ok := vaultSecrets[key]
+	vaultMutex.Unlock()
+	if ok && secret.valid(vaultNow()) {
+		return secret.value, nil
+	}
+
+	value, err, _ := vaultGroup.Do("secret|"+key, func() (interface{}, error) {
+		response, err := config.authorizedRequest(ctx, http.MethodGet, strings.Trim(path, "/"))
+		if err != nil {
+			return "", err
+		}
+		data := response.Get("data")
+		if !data.IsObject() {
+			return "", fmt.Errorf("response contains no data")
+		}
+		secret := vaultLease{value: data.Raw}
+		if lease := response.Get("lease_duration").Int(); lease > 0 {
+			secret.refreshAt = vaultNow().Add(time.Duration(float64(lease) * vaultRefreshFraction * float64(time.Second)))
+		}
+		vaultMutex.Lock()
+		vaultSecrets[key] = secret
+		vaultMutex.Unlock()
+		return secret.value, nil
+	})
+	return value.(string), err
+}
+
+// authorizedRequest sends a request with the client token, logging in again once when Vault
+// rejects a token obtained by a login, which may have been revoked
+func (c vaultConfig) authorizedRequest(ctx context.Context, method, path string) (gjson.Result, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	response, status, err := c.request(ctx, method, path, token, nil)
+	if status == http.StatusForbidden && c.method != "token" {
+		c.forgetToken()
+		if token, err = c.token(ctx); err != nil {
+			return gjson.Result{}, err
+		}
+		response, _, err = c.request(ctx, method, path, token, nil)
+	}
+	return response, err
+}
+
+func (c vaultConfig) tokenKey() string {
+	return c.addr + "|" + c.namespace + "|" + c.method + "|" + c.mount + "|" + c.role
+}
+
+func (c vaultConfig) forgetToken() {
+	vaultMutex.Lock()
+	defer vaultMutex.Unlock()
+	delete(vaultTokens, c.tokenKey())
+}
+
+// token returns VAULT_TOKEN, or a client token from logging in with AppRole or Kubernetes
+// auth that is reused until its lease is close to expiring
+func (c vaultConfig) token(ctx context.Context) (string, error) {
+	if c.method == "token" {
+		return os.Getenv(VAULT_TOKEN_ENV), nil
+	}
+	key := c.tokenKey()
+	vaultMutex.Lock()
+	token, ok := vaultTokens[key]
+	vaultMutex.Unlock()
+	if ok && token.valid(vaultNow()) {
+		return token.value, nil
+	}
+
+	value, err, _ := vaultGroup.Do("token|"+key, func() (interface{}, error) {
+		body, err := c.loginBody()
+		if err != nil {
+			return "", err
+		}
+		response, _, err := c.request(ctx, http.MethodPost, "auth/"+strings.Trim(c.mount, "/")+"/login", "", body)
+		if err != nil {
+			return "", fmt.Errorf("%s login failed: %v", c.method, err)
+		}
+		token := vaultLease{value: response.Get("auth.client_token").String()}
+		if token.value == "" {
+			return "", fmt.Errorf("%s login response contains no client token", c.method)
+		}
+		if lease := response.Get("auth.lease_duration").Int(); lease > 0 {
+			token.refreshAt = vaultNow().Add(time.Duration(float64(lease) * vaultRefreshFraction * float64(time.Second)))
+		}
+		vaultMutex.Lock()
+		vaultTokens[key] = token
+		vaultMutex.Unlock()
+		return token.value, nil
+	})
+	return value.(string), err
+}
+
+func (c vaultConfig) loginBody() (map[string]string, error) {
+	if c.method == "approle" {
+		secretID := os.Getenv(VAULT_SECRET_ID_ENV)
+		if path := os.Getenv(VAULT_SECRET_ID_FILE_ENV); secretID == "" && path != "" {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			secretID = strings.TrimSpace(string(content))
+		}
+		return map[string]string{"role_id": c.role, "secret_id": secretID}, nil
+	}
+	path := os.Getenv(VAULT_KUBERNETES_TOKEN_FILE_ENV)
+	if path == "" {
+		path = VAULT_KUBERNETES_DEFAULT_TOKEN_FILE
+	}
+	jwt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"role": c.role, "jwt": strings.TrimSpace(string(jwt))}, nil
+}
+
+// request sends a request to the Vault HTTP API and returns the parsed response and status
+func (c vaultConfig) request(ctx context.Context, method, path, token string, body interface{}) (gjson.Result, int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return gjson.Result{}, 0, err
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return gjson.Result{}, 0, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if c.namespace != "" {
+		request.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return gjson.Result{}, 0, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return gjson.Result{}, response.StatusCode, err
+	}
+	if response.StatusCode != http.StatusOK {
+		message := http.StatusText(response.StatusCode)
+		if messages := gjson.GetBytes(content, "errors").Array(); len(messages) > 0 {
+			message = messages[0].String()
+		}
+		return gjson.Result{}, response.StatusCode, fmt.Errorf("request failed with status %d: %s", response.StatusCode, message)
+	}
+	if !gjson.ValidBytes(content) {
+		return gjson.Result{}, response.StatusCode, fmt.Errorf("response is not valid JSON")
+	}
+	return gjson.ParseBytes(content), response.StatusCode, nil
+}
@@ -0,0 +1,298 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// vaultStandIn serves the parts of the Vault HTTP API the vault: prefix uses. Secrets are
+// keyed by API path; logins hand out a new client token each time.
+type vaultStandIn struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	secrets   map[string]interface{}
+	leases    map[string]int
+	tokens    map[string]bool
+	logins    []map[string]string
+	reads     map[string]int
+	namespace string
+	tokenTTL  int
+}
+
+func newVaultStandIn(t *testing.T) *vaultStandIn {
+	vault := &vaultStandIn{
+		secrets: map[string]interface{}{
+			"secret/data/myapp": map[string]interface{}{
+				"data":     map[string]interface{}{"password": "kv2-password", "username": "kv2-user"},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+			"kv/myapp": map[string]interface{}{"password": "kv1-password"},
+		},
+		leases:   map[string]int{"kv/myapp": 100},
+		tokens:   map[string]bool{"root-token": true},
+		reads:    map[string]int{},
+		tokenTTL: 60,
+	}
+	vault.Server = httptest.NewServer(http.HandlerFunc(vault.serve))
+	t.Cleanup(vault.Close)
+	for _, name := range []string{VAULT_TOKEN_ENV, VAULT_ROLE_ID_ENV, VAULT_SECRET_ID_ENV, VAULT_SECRET_ID_FILE_ENV,
+		VAULT_KUBERNETES_ROLE_ENV, VAULT_KUBERNETES_TOKEN_FILE_ENV, VAULT_AUTH_MOUNT_ENV, VAULT_NAMESPACE_ENV, VAULT_CACERT_ENV} {
+		t.Setenv(name, "")
+	}
+	t.Setenv(VAULT_ADDR_ENV, vault.URL)
+	return vault
+}
+
+func (v *vaultStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	path := r.URL.Path[len("/v1/"):]
+	if v.namespace != "" && r.Header.Get("X-Vault-Namespace") != v.namespace {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":["wrong namespace"]}`))
+		return
+	}
+	if r.Method == http.MethodPost && filepath.Base(path) == "login" {
+		login := map[string]string{"mount": filepath.Dir(path)}
+		json.NewDecoder(r.Body).Decode(&login)
+		v.logins = append(v.logins, login)
+		token := "login-token-" + string(rune('0'+len(v.logins)))
+		v.tokens[token] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": token, "lease_duration": v.tokenTTL},
+		})
+		return
+	}
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	secret, ok := v.secrets[path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+		return
+	}
+	v.reads[path]++
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": secret, "lease_duration": v.leases[path]})
+}
+
+func (v *vaultStandIn) loginRequests() []map[string]string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return append([]map[string]string{}, v.logins...)
+}
+
+func (v *vaultStandIn) readCount(path string) int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.reads[path]
+}
+
+func TestVaultKV(t *testing.T) {
+	newVaultStandIn(t)
+	t.Setenv(VAULT_TOKEN_ENV, "root-token")
+	for _, test := range []struct {
+		pattern, expected string
+	}{
+		{"vault:secret/data/myapp:$.data.password", "kv2-password"},
+		{"vault:secret/data/myapp:..username", "kv2-user"},
+		{"vault:secret/data/myapp:$.metadata.version", "3"},
+		{"vault:kv/myapp:$.password", "kv1-password"},
+		{"vault:kv/myapp", `{"password":"kv1-password"}`},
+	} {
+		if value, ok := processSearchPattern(context.Background(), "vault", test.pattern, patternOptions{}); !ok || value != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.pattern, value, test.expected)
+		}
+	}
+	for _, pattern := range []string{"vault:secret/data/missing", "vault:", "vault:secret/data/myapp:$.data.missing"} {
+		if _, ok := processSearchPattern(context.Background(), "vault", pattern, patternOptions{}); ok {
+			t.Errorf("Expected %s not to resolve\n", pattern)
+		}
+	}
+
+	t.Setenv(VAULT_TOKEN_ENV, "wrong-token")
+	if _, err := readVaultSecret(context.Background(), "secret/data/other"); err == nil || err.Error() != "request failed with status 403: permission denied" {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "request failed with status 403: permission denied")
+	}
+}
+
+func TestVaultLeases(t *testing.T) {
+	vault := newVaultStandIn(t)
+	t.Setenv(VAULT_TOKEN_ENV, "root-token")
+	now := time.Now()
+	vaultNow = func() time.Time { return now }
+	defer func() { vaultNow = time.Now }()
+
+	for i := 0; i < 3; i++ {
+		readVaultSecret(context.Background(), "kv/myapp")
+		readVaultSecret(context.Background(), "secret/data/myapp")
+	}
+	if vault.readCount("kv/myapp") != 1 || vault.readCount("secret/data/myapp") != 1 {
+		t.Errorf("Expected cached secrets to be read once, got %d and %d reads\n", vault.readCount("kv/myapp"), vault.readCount("secret/data/myapp"))
+	}
+
+	// a new load reads secrets without a lease again, leased ones once the lease is almost over
+	resetVault()
+	readVaultSecret(context.Background(), "kv/myapp")
+	readVaultSecret(context.Background(), "secret/data/myapp")
+	if vault.readCount("kv/myapp") != 1 || vault.readCount("secret/data/myapp") != 2 {
+		t.Errorf("Expected only the secret without a lease to be read again, got %d and %d reads\n", vault.readCount("kv/myapp"), vault.readCount("secret/data/myapp"))
+	}
+	now = now.Add(81 * time.Second)
+	readVaultSecret(context.Background(), "kv/myapp")
+	if vault.readCount("kv/myapp") != 2 {
+		t.Errorf("Expected the leased secret to be read again after 80%% of its lease, got %d reads\n", vault.readCount("kv/myapp"))
+	}
+}
+
+func TestVaultAppRole(t *testing.T) {
+	vault := newVaultStandIn(t)
+	t.Setenv(VAULT_ROLE_ID_ENV, "my-role")
+	secretIDFile := filepath.Join(t.TempDir(), "secret-id")
+	ioutil.WriteFile(secretIDFile, []byte("my-secret-id\n"), 0600)
+	t.Setenv(VAULT_SECRET_ID_FILE_ENV, secretIDFile)
+	now := time.Now()
+	vaultNow = func() time.Time { return now }
+	defer func() { vaultNow = time.Now }()
+
+	if value, ok := processSearchPattern(context.Background(), "vault", "vault:secret/data/myapp:$.data.password", patternOptions{}); !ok || value != "kv2-password" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "kv2-password")
+	}
+	if logins := vault.loginRequests(); len(logins) != 1 || logins[0]["mount"] != "auth/approle" || logins[0]["role_id"] != "my-role" || logins[0]["secret_id"] != "my-secret-id" {
+		t.Errorf("Unexpected AppRole login: %v\n", logins)
+	}
+
+	// the client token is reused within its lease and replaced when it is revoked or expires
+	resetVault()
+	readVaultSecret(context.Background(), "secret/data/myapp")
+	if len(vault.loginRequests()) != 1 {
+		t.Errorf("Expected the client token to be reused, got %d logins\n", len(vault.loginRequests()))
+	}
+	vault.mutex.Lock()
+	vault.tokens = map[string]bool{}
+	vault.mutex.Unlock()
+	resetVault()
+	if _, err := readVaultSecret(context.Background(), "secret/data/myapp"); err != nil || len(vault.loginRequests()) != 2 {
+		t.Errorf("Expected a revoked token to trigger a new login, got %d logins and %v\n", len(vault.loginRequests()), err)
+	}
+	now = now.Add(49 * time.Second)
+	resetVault()
+	readVaultSecret(context.Background(), "secret/data/myapp")
+	if len(vault.loginRequests()) != 3 {
+		t.Errorf("Expected a new login after 80%% of the token lease, got %d logins\n", len(vault.loginRequests()))
+	}
+}
+
+func TestVaultKubernetesAuth(t *testing.T) {
+	vault := newVaultStandIn(t)
+	vault.namespace = "team-a"
+	t.Setenv(VAULT_NAMESPACE_ENV, "team-a")
+	t.Setenv(VAULT_KUBERNETES_ROLE_ENV, "my-app")
+	t.Setenv(VAULT_AUTH_MOUNT_ENV, "k8s-cluster")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(tokenFile, []byte("service-account-jwt"), 0600)
+	t.Setenv(VAULT_KUBERNETES_TOKEN_FILE_ENV, tokenFile)
+
+	if value, ok := processSearchPattern(context.Background(), "vault", "vault:kv/myapp:$.password", patternOptions{}); !ok || value != "kv1-password" {
+		t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "kv1-password")
+	}
+	if logins := vault.loginRequests(); len(logins) != 1 || logins[0]["mount"] != "auth/k8s-cluster" || logins[0]["role"] != "my-app" || logins[0]["jwt"] != "service-account-jwt" {
+		t.Errorf("Unexpected Kubernetes login: %v\n", logins)
+	}
+}
+
+func TestVaultMappings(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	newVaultStandIn(t)
+	t.Setenv(VAULT_TOKEN_ENV, "root-token")
+	t.Setenv("VAULT_FALLBACK", "fallback")
+	Initialize("server/config/vault/mappings.json")
+	for name, expected := range map[string]string{"vault_var1": "kv2-password", "vault_var2": "fallback"} {
+		if value, _ := GetString(name); value != expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", name, value, expected)
+		}
+	}
+}
+
+func TestVaultWatch(t *testing.T) {
+	defer Initialize("server/config/mappings.json")
+	vault := newVaultStandIn(t)
+	vault.leases["kv/myapp"] = 1
+	t.Setenv(VAULT_TOKEN_ENV, "root-token")
+	mappingsFile := t.TempDir() + "/mappings.json"
+	ioutil.WriteFile(mappingsFile, []byte(`{"vault_leased": {"searchPatterns": ["vault:kv/myapp:$.password"]}}`), 0644)
+	Initialize(mappingsFile)
+
+	vault.mutex.Lock()
+	vault.secrets["kv/myapp"] = map[string]interface{}{"password": "rotated-password"}
+	vault.mutex.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, func(name string) { changes <- name })
+	}()
+	select {
+	case name := <-changes:
+		if value, _ := GetString(name); name != "vault_leased" || value != "rotated-password" {
+			t.Errorf("Got: \t%s %s\n Wanted: \t%s %s\n", name, value, "vault_leased", "rotated-password")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for the leased secret to be read again\n")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Got: \t%v\n Wanted: \t%v\n", err, context.Canceled)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	newVaultStandIn(t)
+	os.Unsetenv(VAULT_TOKEN_ENV)
+	if _, err := readVaultSecret(context.Background(), "secret/data/myapp"); err == nil {
+		t.Errorf("Expected an error without authentication\n")
+	}
+	t.Setenv(VAULT_ADDR_ENV, "")
+	t.Setenv(VAULT_TOKEN_ENV, "root-token")
+	if _, err := readVaultSecret(context.Background(), "secret/data/myapp"); err == nil || err.Error() != "VAULT_ADDR is not set" {
+		t.Errorf("Got: \t%v\n Wanted: \t%s\n", err, "VAULT_ADDR is not set")
+	}
+
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer blocking.Close()
+	t.Setenv(VAULT_ADDR_ENV, blocking.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := readVaultSecret(ctx, "secret/data/slow"); err == nil {
+		t.Errorf("Expected the deadline to abort the request\n")
+	}
+}
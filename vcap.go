@@ -0,0 +1,119 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"github.com/tidwall/gjson"
+	"os"
+	"sync"
+)
+
+const VCAP_SERVICES_ENV = "VCAP_SERVICES"
+const VCAP_APPLICATION_ENV = "VCAP_APPLICATION"
+
+// Selectors of the cloudfoundry: prefix returning the credentials of the first service
+// instance with a label or a tag, e.g. cloudfoundry:label=cloudantNoSQLDB
+const CF_SELECTOR_LABEL = "label="
+const CF_SELECTOR_TAG = "tag="
+
+// vcapSnapshot holds VCAP_SERVICES and VCAP_APPLICATION parsed once, with the service
+// instances indexed by name, label and tag. Instances are kept in the order they appear.
+// User-provided instances are documents of their own, decoded once for JSONPath.
+type vcapSnapshot struct {
+	servicesRaw      string
+	applicationRaw   string
+	servicesFound    bool
+	applicationFound bool
+	services         *jsonDocument
+	application      *jsonDocument
+	byName           map[string]gjson.Result
+	userProvided     map[string]*jsonDocument
+	byLabel          map[string][]gjson.Result
+	byTag            map[string][]gjson.Result
+}
+
+var vcapMutex sync.Mutex
+var vcapCurrent *vcapSnapshot
+
+// currentVCAP returns the snapshot of the current load, parsing the environment again only
+// after Initialize started a new load or when the variables changed
+func currentVCAP() *vcapSnapshot {
+	services, servicesFound := os.LookupEnv(VCAP_SERVICES_ENV)
+	application, applicationFound := os.LookupEnv(VCAP_APPLICATION_ENV)
+	vcapMutex.Lock()
+	defer vcapMutex.Unlock()
+	if current := vcapCurrent; current != nil && current.servicesFound == servicesFound && current.applicationFound == applicationFound &&
+		current.servicesRaw == services && current.applicationRaw == application {
+		return current
+	}
+	vcapCurrent = newVCAPSnapshot(services, servicesFound, application, applicationFound)
+	return vcapCurrent
+}
+
+func resetVCAP() {
+	vcapMutex.Lock()
+	defer vcapMutex.Unlock()
+	vcapCurrent = nil
+}
+
+func newVCAPSnapshot(services string, servicesFound bool, application string, applicationFound bool) *vcapSnapshot {
+	snapshot := &vcapSnapshot{
+		servicesRaw:      services,
+		applicationRaw:   application,
+		servicesFound:    servicesFound,
+		applicationFound: applicationFound,
+		services:         newJSONDocument(services),
+		application:      newJSONDocument(application),
+		byName:           make(map[string]gjson.Result),
+		userProvided:     make(map[string]*jsonDocument),
+		byLabel:          make(map[string][]gjson.Result),
+		byTag:            make(map[string][]gjson.Result),
+	}
+	if !snapshot.services.valid {
+		return snapshot
+	}
+	snapshot.services.result.ForEach(func(label, instances gjson.Result) bool {
+		instances.ForEach(func(_, instance gjson.Result) bool {
+			name := instance.Get("name").String()
+			if _, found := snapshot.byName[name]; !found {
+				snapshot.byName[name] = instance
+			}
+			if _, found := snapshot.userProvided[name]; !found && label.String() == PREFIX_PATTERN_USER {
+				snapshot.userProvided[name] = &jsonDocument{valid: true, result: instance}
+			}
+			snapshot.byLabel[label.String()] = append(snapshot.byLabel[label.String()], instance)
+			if instanceLabel := instance.Get("label").String(); instanceLabel != "" && instanceLabel != label.String() {
+				snapshot.byLabel[instanceLabel] = append(snapshot.byLabel[instanceLabel], instance)
+			}
+			instance.Get("tags").ForEach(func(_, tag gjson.Result) bool {
+				snapshot.byTag[tag.String()] = append(snapshot.byTag[tag.String()], instance)
+				return true
+			})
+			return true
+		})
+		return true
+	})
+	return snapshot
+}
+
+// firstCredentials returns the credentials of the first instance of a label or tag index
+func firstCredentials(instances []gjson.Result) (string, bool) {
+	if len(instances) == 0 {
+		return "", false
+	}
+	return formatGJSON(instances[0].Get("credentials"))
+}
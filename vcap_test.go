@@ -0,0 +1,152 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+const vcapIndexServices = `{
+	"cloudantNoSQLDB": [
+		{"name": "cloudant-1", "label": "cloudantNoSQLDB", "tags": ["data_management", "cloudant"], "credentials": {"url": "https://cloudant-1"}},
+		{"name": "cloudant-2", "label": "cloudantNoSQLDB", "tags": ["cloudant"], "credentials": {"url": "https://cloudant-2"}}
+	],
+	"user-provided": [
+		{"name": "cloudant-1", "label": "user-provided", "credentials": {"url": "https://user-provided"}}
+	]
+}`
+
+func TestVCAPIndexes(t *testing.T) {
+	t.Setenv("VCAP_SERVICES", vcapIndexServices)
+	for _, test := range []struct {
+		pattern, expected string
+	}{
+		{"cloudfoundry:cloudant-1", `{"url": "https://cloudant-1"}`},
+		{"cloudfoundry:label=cloudantNoSQLDB", `{"url": "https://cloudant-1"}`},
+		{"cloudfoundry:tag=cloudant", `{"url": "https://cloudant-1"}`},
+		{"cloudfoundry:label=user-provided", `{"url": "https://user-provided"}`},
+		{"cloudfoundry:$.cloudantNoSQLDB[1].credentials.url", "https://cloudant-2"},
+		{"user-provided:cloudant-1:url", "https://user-provided"},
+	} {
+		if value, ok := processSearchPattern(context.Background(), "vcap", test.pattern, patternOptions{}); !ok || value != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.pattern, value, test.expected)
+		}
+	}
+	if _, ok := processSearchPattern(context.Background(), "vcap", "cloudfoundry:tag=missing", patternOptions{}); ok {
+		t.Errorf("Expected no instance for an unknown tag\n")
+	}
+}
+
+func TestVCAPCredentialsRaw(t *testing.T) {
+	credentials := `{"username": "admin", "password": "s3cret", "nested": {"port": 5984}}`
+	t.Setenv("VCAP_SERVICES", `{"cloudantNoSQLDB": [{"name": "cloudant-1", "tags": ["cloudant"], "credentials": `+credentials+`}]}`)
+	for _, test := range []struct {
+		pattern, expected string
+	}{
+		{"cloudfoundry:cloudant-1", credentials},
+		{"cloudfoundry:tag=cloudant", credentials},
+		{"cloudfoundry:..nested", `{"port": 5984}`},
+		{"cloudfoundry:..port", "5984"},
+	} {
+		if value, ok := processSearchPattern(context.Background(), "vcap", test.pattern, patternOptions{}); !ok || value != test.expected {
+			t.Errorf("%s Got: \t%s\n Wanted: \t%s\n", test.pattern, value, test.expected)
+		}
+	}
+}
+
+func TestVCAPUserProvidedDecodedOnce(t *testing.T) {
+	t.Setenv("VCAP_SERVICES", vcapIndexServices)
+	instance := currentVCAP().userProvided["cloudant-1"]
+	if instance.decoded != nil {
+		t.Errorf("Expected the instance not to be decoded before a JSONPath is applied\n")
+	}
+	for i := 0; i < 2; i++ {
+		if value, ok := processSearchPattern(context.Background(), "vcap", "user-provided:cloudant-1:$.credentials.url", patternOptions{}); !ok || value != "https://user-provided" {
+			t.Errorf("Got: \t%s\n Wanted: \t%s\n", value, "https://user-provided")
+		}
+	}
+	if currentVCAP().userProvided["cloudant-1"] != instance || instance.decoded == nil {
+		t.Errorf("Expected the decoded instance to be kept in the snapshot\n")
+	}
+}
+
+func TestVCAPSnapshotReuse(t *testing.T) {
+	t.Setenv("VCAP_SERVICES", vcapIndexServices)
+	first := currentVCAP()
+	if currentVCAP() != first {
+		t.Errorf("Expected the snapshot to be reused while VCAP_SERVICES is unchanged\n")
+	}
+
+	t.Setenv("VCAP_SERVICES", `{"user-provided": [{"name": "changed", "credentials": {"key": "value"}}]}`)
+	if value, ok := processSearchPattern(context.Background(), "vcap", "user-provided:changed:key", patternOptions{}); !ok || value != "value" {
+		t.Errorf("Expected a changed VCAP_SERVICES to be parsed again, got: %s\n", value)
+	}
+
+	second := currentVCAP()
+	Initialize("server/config/mappings.json")
+	if currentVCAP() == second {
+		t.Errorf("Expected Initialize to start a new snapshot\n")
+	}
+}
+
+// benchmarkVCAPServices returns VCAP_SERVICES with instances instances, like large apps have
+func benchmarkVCAPServices(instances int) string {
+	entries := make([]string, instances)
+	for i := range entries {
+		entries[i] = fmt.Sprintf(`{"name": "service-%d", "label": "user-provided", "tags": ["tag-%d"], "credentials": {"apikey": "apikey-%d", "nested": {"url": "https://service-%d"}}}`, i, i, i, i)
+	}
+	return `{"user-provided": [` + strings.Join(entries, ",") + `]}`
+}
+
+var benchmarkPatterns = []string{
+	"cloudfoundry:service-150",
+	"cloudfoundry:$['user-provided'][150].credentials.apikey",
+	"user-provided:service-150:nested.url",
+	"cloudfoundry:tag=tag-150",
+}
+
+func benchmarkVCAPPatterns(b *testing.B, reparse bool) {
+	os.Setenv("VCAP_SERVICES", benchmarkVCAPServices(300))
+	defer os.Unsetenv("VCAP_SERVICES")
+	resetVCAP()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, pattern := range benchmarkPatterns {
+			if reparse {
+				resetVCAP()
+			}
+			if _, ok := processSearchPattern(context.Background(), "benchmark", pattern, patternOptions{}); !ok {
+				b.Fatalf("%s did not resolve", pattern)
+			}
+		}
+	}
+}
+
+// BenchmarkVCAPPatternsSnapshot resolves patterns against the snapshot of the current load
+func BenchmarkVCAPPatternsSnapshot(b *testing.B) {
+	benchmarkVCAPPatterns(b, false)
+}
+
+// BenchmarkVCAPPatternsReparse parses VCAP_SERVICES for every pattern, as was done before
+// the snapshot
+func BenchmarkVCAPPatternsReparse(b *testing.B) {
+	benchmarkVCAPPatterns(b, true)
+}
@@ -0,0 +1,183 @@
+/*
+ * © Copyright IBM Corp. 2018
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package IBMCloudEnv
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kvClient reads from key-value stores; kvWatchClient has no timeout for requests that
+// block until a key changes, relying on their context instead
+var kvClient = &http.Client{Timeout: 30 * time.Second}
+var kvWatchClient = &http.Client{}
+
+// watchRetryDelay is how long a failed watch waits before it starts again
+var watchRetryDelay = 5 * time.Second
+
+// sourceWatcher blocks until the source of a search pattern changes after it was last read
+type sourceWatcher func(ctx context.Context, patternComponents []string) error
+
+// sourceWatchers are the search pattern prefixes whose sources can be watched
+var sourceWatchers = map[string]sourceWatcher{
+	PREFIX_PATTERN_CONSUL: watchConsulKey,
+	PREFIX_PATTERN_ETCD:   watchEtcdKey,
+	PREFIX_PATTERN_VAULT:  watchVaultSecret,
+}
+
+var watchMutex sync.Mutex
+var watchIndexes = make(map[string]uint64)
+
+// recordWatchIndex records the index or revision a source was read at, so a watch reports
+// the changes made since
+func recordWatchIndex(source string, index uint64) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	watchIndexes[source] = index
+}
+
+func watchIndex(source string) (uint64, bool) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	index, ok := watchIndexes[source]
+	return index, ok
+}
+
+// subtree returns the keys under prefix as nested objects, e.g. db/host below config/ as
+// {"db": {"host": ...}}. Keys ending with /, which stores use for folders, are left out.
+func subtree(values map[string]string, prefix string) map[string]interface{} {
+	relative := make(map[string]string)
+	for key, value := range values {
+		if strings.HasPrefix(key, prefix) && key != prefix && !strings.HasSuffix(key, "/") {
+			relative[key[len(prefix):]] = value
+		}
+	}
+	return nestKeys(relative, "/")
+}
+
+// Watch keeps the mappings of the last Initialize that were resolved from a consul:, etcd: or
+// vault: search pattern up to date until ctx ends. When the key of such a pattern changes, or
+// 80% of the lease of a Vault secret has passed, the mapping is resolved again
+// together with the templates that reference it, and onChange, if not nil, is called with
+// the name of each mapping resolved again. onChange is not called concurrently. A mapping
+// is no longer watched once it is resolved from a source that cannot be watched, or once it
+// is not part of the last Initialize.
+// Watch returns right away when no mapping can be watched, and ctx.Err() otherwise. Mappings
+// of lazy mode are only watched when they were resolved before Watch is called.
+func Watch(ctx context.Context, onChange func(name string)) error {
+	var wg sync.WaitGroup
+	var changeMutex sync.Mutex
+	for _, status := range GetMappingStatuses() {
+		watcher, ok := sourceWatchers[status.Source]
+		if !ok || !status.Resolved || !currentMapping(status.Name) {
+			continue
+		}
+		wg.Add(1)
+		go func(name, pattern string) {
+			defer wg.Done()
+			watchMapping(ctx, name, pattern, watcher, func(names []string) {
+				if onChange == nil {
+					return
+				}
+				changeMutex.Lock()
+				defer changeMutex.Unlock()
+				for _, name := range names {
+					onChange(name)
+				}
+			})
+		}(status.Name, status.searchPattern)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return ctx.Err()
+	case <-ctx.Done():
+		<-done
+		return ctx.Err()
+	}
+}
+
+// watchMapping watches the source of a mapping until ctx ends, the mapping is not part of
+// the last Initialize any more or it is resolved from a source that cannot be watched
+func watchMapping(ctx context.Context, name, pattern string, watcher sourceWatcher, changed func(names []string)) {
+	for ctx.Err() == nil && currentMapping(name) {
+		if err := watcher(ctx, splitSearchPattern(pattern)); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnln("Watching searchPattern", redactPattern(pattern), "for mapping", name, "failed:", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(watchRetryDelay):
+			}
+			continue
+		}
+		log.Infoln("Source of mapping", name, "changed, resolving it again")
+		changed(reloadMapping(ctx, name))
+
+		// the mapping may now be resolved from another search pattern, which is watched instead
+		if status, ok := mappingStatus(name); ok && status.Resolved && status.searchPattern != pattern {
+			next, ok := sourceWatchers[status.Source]
+			if !ok {
+				log.Infoln("Mapping", name, "is now resolved from", status.Source, "which cannot be watched")
+				return
+			}
+			pattern, watcher = status.searchPattern, next
+		}
+	}
+}
+
+// reloadMapping resolves a mapping of the last Initialize again, followed by the templates
+// of that load that reference it directly or through other templates, and returns their names
+func reloadMapping(ctx context.Context, name string) []string {
+	definitionsMutex.RLock()
+	definitions := make(map[string]mappingDefinition, len(currentMappings))
+	for key, definition := range mappingDefinitions {
+		if currentMappings[key] {
+			definitions[key] = definition
+		}
+	}
+	definitionsMutex.RUnlock()
+
+	definition, ok := definitions[name]
+	if !ok {
+		return nil
+	}
+	resolveDefinition(ctx, name, definition)
+	reloaded := []string{name}
+	for i := 0; i < len(reloaded); i++ {
+		for _, template := range sortedKeys(definitions) {
+			if !definitions[template].template || indexOf(reloaded, template) >= 0 {
+				continue
+			}
+			references := templateReferences(definitions[template].config.Get("template").String())
+			if indexOf(references, reloaded[i]) >= 0 {
+				resolveDefinition(ctx, template, definitions[template])
+				reloaded = append(reloaded, template)
+			}
+		}
+	}
+	return reloaded
+}